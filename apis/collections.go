@@ -0,0 +1,99 @@
+package apis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/paths"
+)
+
+const (
+	collectionsFilename = "collections.yaml"
+)
+
+// Collections are curated and ordered lists of codelabs, like learning paths
+type Collections map[string]collection
+
+type collection struct {
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Category    string             `json:"category"`
+	Codelabs    []collectionMember `json:"codelabs"`
+}
+
+// collectionMember is a codelab in a collection, linked to its neighbours
+type collectionMember struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+// collectionDef is the collection definition as written in the metadata file
+type collectionDef struct {
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	Category    string   `yaml:"category"`
+	Codelabs    []string `yaml:"codelabs"`
+}
+
+// NewCollections return all collections for main site, validated against available codelabs.
+// The collections file is optional.
+func NewCollections(codelabs []codelab.Codelab) (*Collections, error) {
+	c := Collections{}
+	p := paths.New()
+
+	f := path.Join(p.MetaData, collectionsFilename)
+	dat, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read from %s: %v", f, err)
+	}
+	defs := make(map[string]collectionDef)
+	if err := yaml.Unmarshal(dat, &defs); err != nil {
+		return nil, fmt.Errorf("couldn't decode %s: %v", f, err)
+	}
+
+	ids := make(map[string]bool)
+	for _, clab := range codelabs {
+		ids[clab.ID] = true
+	}
+
+	for name, def := range defs {
+		col := collection{
+			Title:       def.Title,
+			Description: def.Description,
+			Category:    def.Category,
+			Codelabs:    make([]collectionMember, 0, len(def.Codelabs)),
+		}
+		seen := make(map[string]bool)
+		for i, id := range def.Codelabs {
+			if !ids[id] {
+				return nil, fmt.Errorf("collection %s in %s references unknown codelab %s", name, f, id)
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("collection %s in %s references codelab %s multiple times", name, f, id)
+			}
+			seen[id] = true
+
+			m := collectionMember{ID: id, Position: i + 1}
+			if i > 0 {
+				m.Previous = def.Codelabs[i-1]
+			}
+			if i < len(def.Codelabs)-1 {
+				m.Next = def.Codelabs[i+1]
+			}
+			col.Codelabs = append(col.Codelabs, m)
+		}
+		c[name] = col
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,69 @@
+package apis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/didrocks/codelab-ubuntu-tools/claat/types"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/paths"
+)
+
+func TestNewCollections(t *testing.T) {
+	allCodelabs := []codelab.Codelab{
+		codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "123"}}},
+		codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "456"}}},
+		codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "789"}}},
+	}
+	testCases := []struct {
+		collectionsDir string
+		codelabs       []codelab.Codelab
+
+		wantCollections Collections
+		wantErr         bool
+	}{
+		{"testdata/collections/valid", allCodelabs,
+			Collections{
+				"snap-developer": collection{Title: "Snap developer track", Description: "From your first snap to publishing it in the store.", Category: "snapcraft",
+					Codelabs: []collectionMember{
+						collectionMember{ID: "123", Position: 1, Next: "456"},
+						collectionMember{ID: "456", Position: 2, Previous: "123", Next: "789"},
+						collectionMember{ID: "789", Position: 3, Previous: "456"},
+					}},
+				"single": collection{Title: "Single codelab", Description: "A collection with only one codelab.", Category: "snap",
+					Codelabs: []collectionMember{
+						collectionMember{ID: "456", Position: 1},
+					}},
+			},
+			false},
+		{"testdata/collections/valid", allCodelabs[:2], nil, true}, // 789 is missing
+		{"doesnt/exist", allCodelabs, Collections{}, false},        // collections are optional
+		{"testdata/collections/no-collections", allCodelabs, Collections{}, false},
+		{"testdata/collections/unknown-codelab", allCodelabs, nil, true},
+		{"testdata/collections/duplicate-codelab", allCodelabs, nil, true},
+		{"testdata/collections/invalid", allCodelabs, nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("create collections for: %+v with %d codelabs", tc.collectionsDir, len(tc.codelabs)), func(t *testing.T) {
+			// Setup/Teardown
+			p, teardown := paths.MockPath()
+			defer teardown()
+			p.MetaData = tc.collectionsDir
+
+			// Test
+			c, err := NewCollections(tc.codelabs)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewCollections() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(*c, tc.wantCollections) {
+				t.Errorf("Generated collections: got %+v; want %+v", *c, tc.wantCollections)
+			}
+		})
+	}
+}
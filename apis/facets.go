@@ -0,0 +1,80 @@
+package apis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+// facets are aggregates over all codelabs, for filtering them on the website
+type facets struct {
+	Categories     map[string]int `json:"categories"`
+	Tags           map[string]int `json:"tags"`
+	Difficulties   map[int]int    `json:"difficulties"`
+	Statuses       map[string]int `json:"statuses"`
+	Events         map[string]int `json:"events"`
+	TotalDuration  int            `json:"totalduration"`
+	MedianDuration float64        `json:"medianduration"`
+}
+
+// newFacets counts codelabs per category, tag, difficulty, status and event
+// and computes their duration statistics.
+func newFacets(codelabs []codelab.Codelab, events Events) facets {
+	f := facets{
+		Categories:   make(map[string]int),
+		Tags:         make(map[string]int),
+		Difficulties: make(map[int]int),
+		Statuses:     make(map[string]int),
+		Events:       make(map[string]int),
+	}
+
+	var durations []int
+	for _, c := range codelabs {
+		for _, cat := range c.Categories {
+			f.Categories[cat]++
+		}
+		for _, tag := range c.Tags {
+			f.Tags[tag]++
+		}
+		// 0 means that no difficulty has been set
+		if c.Difficulty != 0 {
+			f.Difficulties[c.Difficulty]++
+		}
+		if c.Status != nil {
+			for _, s := range *c.Status {
+				f.Statuses[strings.ToLower(s)]++
+			}
+		}
+		for _, e := range codelabEvents(c, events) {
+			f.Events[e]++
+		}
+		// 0 means that no duration has been set: it doesn't count for the median either
+		if c.Duration != 0 {
+			f.TotalDuration += c.Duration
+			durations = append(durations, c.Duration)
+		}
+	}
+
+	if n := len(durations); n > 0 {
+		sort.Ints(durations)
+		if n%2 == 1 {
+			f.MedianDuration = float64(durations[n/2])
+		} else {
+			f.MedianDuration = float64(durations[n/2-1]+durations[n/2]) / 2
+		}
+	}
+
+	return f
+}
+
+// codelabEvents returns the events a codelab is part of: a codelab is tagged with event names.
+func codelabEvents(c codelab.Codelab, events Events) []string {
+	var r []string
+	for _, tag := range c.Tags {
+		if _, ok := events[tag]; ok {
+			r = append(r, tag)
+		}
+	}
+	return r
+}
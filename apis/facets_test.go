@@ -0,0 +1,69 @@
+package apis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/didrocks/codelab-ubuntu-tools/claat/types"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestNewFacets(t *testing.T) {
+	published := types.LegacyStatus([]string{"Published"})
+	draft := types.LegacyStatus([]string{"draft"})
+	events := Events{"event-1": event{Name: "Event 1"}, "event-2": event{Name: "Event 2"}}
+
+	testCases := []struct {
+		name     string
+		codelabs []codelab.Codelab
+
+		wantFacets facets
+	}{
+		{"no codelab", nil,
+			facets{Categories: map[string]int{}, Tags: map[string]int{}, Difficulties: map[int]int{}, Statuses: map[string]int{}, Events: map[string]int{}}},
+		{"one codelab",
+			[]codelab.Codelab{
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "a", Status: &published, Categories: []string{"snap"}, Tags: []string{"foo", "event-1"}, Difficulty: 2, Duration: 10}}},
+			},
+			facets{Categories: map[string]int{"snap": 1}, Tags: map[string]int{"foo": 1, "event-1": 1}, Difficulties: map[int]int{2: 1},
+				Statuses: map[string]int{"published": 1}, Events: map[string]int{"event-1": 1}, TotalDuration: 10, MedianDuration: 10}},
+		{"odd number of codelabs",
+			[]codelab.Codelab{
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "a", Status: &published, Categories: []string{"snap"}, Tags: []string{"foo", "event-1"}, Difficulty: 2, Duration: 10}}},
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "b", Status: &draft, Categories: []string{"snap", "server"}, Tags: []string{"foo", "event-2"}, Difficulty: 2, Duration: 50}}},
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "c", Status: &published, Categories: []string{"server"}, Tags: []string{"bar", "event-1"}, Difficulty: 3, Duration: 20}}},
+			},
+			facets{Categories: map[string]int{"snap": 2, "server": 2}, Tags: map[string]int{"foo": 2, "bar": 1, "event-1": 2, "event-2": 1}, Difficulties: map[int]int{2: 2, 3: 1},
+				Statuses: map[string]int{"published": 2, "draft": 1}, Events: map[string]int{"event-1": 2, "event-2": 1}, TotalDuration: 80, MedianDuration: 20}},
+		{"even number of codelabs",
+			[]codelab.Codelab{
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "a", Duration: 10}}},
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "b", Duration: 25}}},
+			},
+			facets{Categories: map[string]int{}, Tags: map[string]int{}, Difficulties: map[int]int{}, Statuses: map[string]int{}, Events: map[string]int{},
+				TotalDuration: 35, MedianDuration: 17.5}},
+		{"codelabs without duration aren't part of the median",
+			[]codelab.Codelab{
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "a", Duration: 10}}},
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "b"}}},
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "c"}}},
+			},
+			facets{Categories: map[string]int{}, Tags: map[string]int{}, Difficulties: map[int]int{}, Statuses: map[string]int{}, Events: map[string]int{},
+				TotalDuration: 10, MedianDuration: 10}},
+		{"tags which aren't events aren't counted as events",
+			[]codelab.Codelab{
+				codelab.Codelab{Codelab: types.Codelab{Meta: types.Meta{ID: "a", Tags: []string{"event-3"}}}},
+			},
+			facets{Categories: map[string]int{}, Tags: map[string]int{"event-3": 1}, Difficulties: map[int]int{}, Statuses: map[string]int{}, Events: map[string]int{}}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("facets for %s", tc.name), func(t *testing.T) {
+			f := newFacets(tc.codelabs, events)
+
+			if !reflect.DeepEqual(f, tc.wantFacets) {
+				t.Errorf("got %+v; want %+v", f, tc.wantFacets)
+			}
+		})
+	}
+}
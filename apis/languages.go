@@ -0,0 +1,66 @@
+package apis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+// defaultLanguage is the language of codelabs which don't declare any
+const defaultLanguage = "en"
+
+// groupVariants returns one codelab per ID, the main variant, listing all available languages.
+// The main variant is the one without any declared language, then the default language one,
+// then the first one in alphabetical order.
+// Two codelabs sharing the same ID and language are an error.
+func groupVariants(codelabs []codelab.Codelab) ([]codelab.Codelab, error) {
+	var ids []string
+	variants := make(map[string][]codelab.Codelab)
+	for _, c := range codelabs {
+		vs, ok := variants[c.ID]
+		if !ok {
+			ids = append(ids, c.ID)
+		}
+		for _, v := range vs {
+			if language(v) == language(c) {
+				return nil, fmt.Errorf("%s and %s are both defining codelab %q in language %q", v.RefURI, c.RefURI, c.ID, language(c))
+			}
+		}
+		variants[c.ID] = append(vs, c)
+	}
+
+	r := make([]codelab.Codelab, 0, len(ids))
+	for _, id := range ids {
+		vs := variants[id]
+		sort.SliceStable(vs, func(i, j int) bool {
+			if vs[i].Language == "" || vs[j].Language == "" {
+				return vs[i].Language == ""
+			}
+			if language(vs[i]) == defaultLanguage || language(vs[j]) == defaultLanguage {
+				return language(vs[i]) == defaultLanguage
+			}
+			return vs[i].Language < vs[j].Language
+		})
+
+		main := vs[0]
+		main.Languages = nil
+		// only list languages for codelabs having translations or declaring their language
+		if len(vs) > 1 || main.Language != "" {
+			for _, v := range vs {
+				main.Languages = append(main.Languages, language(v))
+			}
+			sort.Strings(main.Languages)
+		}
+		r = append(r, main)
+	}
+	return r, nil
+}
+
+// language returns the codelab language, using the default one if none is declared
+func language(c codelab.Codelab) string {
+	if c.Language == "" {
+		return defaultLanguage
+	}
+	return c.Language
+}
@@ -0,0 +1,65 @@
+package apis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestGroupVariants(t *testing.T) {
+	testCases := []struct {
+		name     string
+		codelabs []codelab.Codelab
+
+		wantCodelabs []codelab.Codelab
+		wantErr      bool
+	}{
+		{"no variant",
+			[]codelab.Codelab{variant("a", ""), variant("b", "")},
+			[]codelab.Codelab{variant("a", ""), variant("b", "")}, false},
+		{"main variant without declared language",
+			[]codelab.Codelab{variant("a", "fr"), variant("a", ""), variant("a", "de")},
+			[]codelab.Codelab{variant("a", "", "de", "en", "fr")}, false},
+		{"main variant in default language",
+			[]codelab.Codelab{variant("a", "fr"), variant("a", "en")},
+			[]codelab.Codelab{variant("a", "en", "en", "fr")}, false},
+		{"main variant in alphabetical order",
+			[]codelab.Codelab{variant("a", "fr"), variant("a", "de")},
+			[]codelab.Codelab{variant("a", "de", "de", "fr")}, false},
+		{"only one declared language",
+			[]codelab.Codelab{variant("a", "fr")},
+			[]codelab.Codelab{variant("a", "fr", "fr")}, false},
+		{"order of codelabs is preserved",
+			[]codelab.Codelab{variant("b", "fr"), variant("a", ""), variant("b", "")},
+			[]codelab.Codelab{variant("b", "", "en", "fr"), variant("a", "")}, false},
+		{"duplicated codelab", []codelab.Codelab{variant("a", ""), variant("a", "")}, nil, true},
+		{"duplicated codelab with same language", []codelab.Codelab{variant("a", "fr"), variant("a", "fr")}, nil, true},
+		{"duplicated codelab with default language", []codelab.Codelab{variant("a", ""), variant("a", "en")}, nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("group variants: %s", tc.name), func(t *testing.T) {
+			r, err := groupVariants(tc.codelabs)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("groupVariants() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(r, tc.wantCodelabs) {
+				t.Errorf("got %+v; want %+v", r, tc.wantCodelabs)
+			}
+		})
+	}
+}
+
+func variant(id, lang string, languages ...string) codelab.Codelab {
+	c := newCodelab(id, true)
+	c.RefURI = fmt.Sprintf("%s.%s.md", id, lang)
+	c.Language = lang
+	c.Languages = languages
+	return c
+}
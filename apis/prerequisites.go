@@ -0,0 +1,109 @@
+package apis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+// linkPrerequisites checks that every prerequisite exists, is published and that
+// they don't form any cycle. It then fills for each codelab the ones it unlocks.
+func linkPrerequisites(codelabs []codelab.Codelab) error {
+	byID := make(map[string]*codelab.Codelab)
+	for i := range codelabs {
+		c := &codelabs[i]
+		c.Unlocks = nil
+		byID[c.ID] = c
+	}
+
+	for i := range codelabs {
+		c := &codelabs[i]
+		for _, id := range c.Prerequisites {
+			p, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("%s has an unknown prerequisite: %s", c.ID, id)
+			}
+			if !p.IsPublished() {
+				return fmt.Errorf("%s has a prerequisite which isn't published: %s", c.ID, id)
+			}
+			p.Unlocks = append(p.Unlocks, c.ID)
+		}
+	}
+	for i := range codelabs {
+		sort.Strings(codelabs[i].Unlocks)
+	}
+
+	// detect cycles with a depth first search, keeping current path for reporting
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case inProgress:
+			return fmt.Errorf("prerequisites are forming a cycle: %s -> %s", strings.Join(path, " -> "), id)
+		case done:
+			return nil
+		}
+		state[id] = inProgress
+		path = append(path, id)
+		for _, p := range byID[id].Prerequisites {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return nil
+	}
+	for _, c := range codelabs {
+		if err := visit(c.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteDependencyGraph exports the prerequisite graph of all codelabs in DOT format.
+// Language variants of a codelab are a single node. Codelabs which aren't published yet are dashed.
+func WriteDependencyGraph(w io.Writer, c []codelab.Codelab) error {
+	// work on a copy, with one element per codelab, as we are linking codelabs between them
+	codelabs, err := groupVariants(c)
+	if err != nil {
+		return err
+	}
+	if err := linkPrerequisites(codelabs); err != nil {
+		return err
+	}
+	sort.Slice(codelabs, func(i, j int) bool { return codelabs[i].ID < codelabs[j].ID })
+
+	if _, err := fmt.Fprintln(w, "digraph codelabs {"); err != nil {
+		return err
+	}
+	for _, c := range codelabs {
+		style := ""
+		if !c.IsPublished() {
+			style = ", style=dashed"
+		}
+		if _, err := fmt.Fprintf(w, "  %q [label=%q%s];\n", c.ID, c.Title, style); err != nil {
+			return err
+		}
+	}
+	for _, c := range codelabs {
+		for _, u := range c.Unlocks {
+			if _, err := fmt.Fprintf(w, "  %q -> %q;\n", c.ID, u); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
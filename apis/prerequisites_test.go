@@ -0,0 +1,101 @@
+package apis
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/didrocks/codelab-ubuntu-tools/claat/types"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestLinkPrerequisites(t *testing.T) {
+	testCases := []struct {
+		name     string
+		codelabs []codelab.Codelab
+
+		wantUnlocks map[string][]string
+		wantErr     bool
+	}{
+		{"no prerequisites", []codelab.Codelab{newCodelab("a", true), newCodelab("b", true)},
+			map[string][]string{"a": nil, "b": nil}, false},
+		{"one prerequisite", []codelab.Codelab{newCodelab("a", true), newCodelab("b", true, "a")},
+			map[string][]string{"a": []string{"b"}, "b": nil}, false},
+		{"chain of prerequisites", []codelab.Codelab{newCodelab("a", true), newCodelab("b", true, "a"), newCodelab("c", false, "b")},
+			map[string][]string{"a": []string{"b"}, "b": []string{"c"}, "c": nil}, false},
+		{"multiple unlocks are sorted", []codelab.Codelab{newCodelab("a", true), newCodelab("c", true, "a"), newCodelab("b", true, "a")},
+			map[string][]string{"a": []string{"b", "c"}, "b": nil, "c": nil}, false},
+		{"multiple prerequisites", []codelab.Codelab{newCodelab("a", true), newCodelab("b", true), newCodelab("c", true, "a", "b")},
+			map[string][]string{"a": []string{"c"}, "b": []string{"c"}, "c": nil}, false},
+		{"unknown prerequisite", []codelab.Codelab{newCodelab("a", true, "doesnt-exist")}, nil, true},
+		{"unpublished prerequisite", []codelab.Codelab{newCodelab("a", false), newCodelab("b", true, "a")}, nil, true},
+		{"self cycle", []codelab.Codelab{newCodelab("a", true, "a")}, nil, true},
+		{"cycle", []codelab.Codelab{newCodelab("a", true, "c"), newCodelab("b", true, "a"), newCodelab("c", true, "b")}, nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("link prerequisites: %s", tc.name), func(t *testing.T) {
+			err := linkPrerequisites(tc.codelabs)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("linkPrerequisites() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			unlocks := make(map[string][]string)
+			for _, c := range tc.codelabs {
+				unlocks[c.ID] = c.Unlocks
+			}
+			if !reflect.DeepEqual(unlocks, tc.wantUnlocks) {
+				t.Errorf("got %+v; want %+v", unlocks, tc.wantUnlocks)
+			}
+		})
+	}
+}
+
+func TestWriteDependencyGraph(t *testing.T) {
+	bFr := newCodelab("b", true, "a")
+	bFr.Language = "fr"
+	codelabs := []codelab.Codelab{newCodelab("b", true, "a"), newCodelab("a", true), newCodelab("c", false, "a", "b"), bFr}
+	want := `digraph codelabs {
+  "a" [label="Title a"];
+  "b" [label="Title b"];
+  "c" [label="Title c", style=dashed];
+  "a" -> "b";
+  "a" -> "c";
+  "b" -> "c";
+}
+`
+
+	var b bytes.Buffer
+	if err := WriteDependencyGraph(&b, codelabs); err != nil {
+		t.Fatalf("WriteDependencyGraph() unexpected error: %v", err)
+	}
+	if b.String() != want {
+		t.Errorf("got %s; want %s", b.String(), want)
+	}
+
+	// original codelabs are untouched
+	for _, c := range codelabs {
+		if c.Unlocks != nil {
+			t.Errorf("%s has been modified: unlocks: %+v", c.ID, c.Unlocks)
+		}
+	}
+
+	if err := WriteDependencyGraph(&b, []codelab.Codelab{newCodelab("a", true, "a")}); err == nil {
+		t.Error("WriteDependencyGraph() expected an error on cycles and got none")
+	}
+}
+
+func newCodelab(id string, published bool, prerequisites ...string) codelab.Codelab {
+	status := types.LegacyStatus([]string{"Draft"})
+	if published {
+		status = types.LegacyStatus([]string{"Published"})
+	}
+	return codelab.Codelab{
+		Codelab:       types.Codelab{Meta: types.Meta{ID: id, Title: fmt.Sprintf("Title %s", id), Status: &status}},
+		Prerequisites: prerequisites,
+	}
+}
@@ -0,0 +1,98 @@
+package apis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+// maxRelated is the maximum number of computed related codelabs. Pinned ones can exceed it.
+const maxRelated = 3
+
+// linkRelated fills for each codelab the published codelabs it shares the most tags and
+// categories with. Pinned codelabs are always listed first and banned ones never listed.
+func linkRelated(codelabs []codelab.Codelab, events Events) error {
+	byID := make(map[string]codelab.Codelab)
+	for _, c := range codelabs {
+		byID[c.ID] = c
+	}
+
+	for i := range codelabs {
+		c := &codelabs[i]
+		c.Related = nil
+
+		excluded := map[string]bool{c.ID: true}
+		for _, id := range c.RelatedBans {
+			excluded[id] = true
+		}
+		for _, id := range c.RelatedPins {
+			p, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("%s has an unknown related codelab: %s", c.ID, id)
+			}
+			if !p.IsPublished() {
+				return fmt.Errorf("%s has a related codelab which isn't published: %s", c.ID, id)
+			}
+			if excluded[id] {
+				continue
+			}
+			c.Related = append(c.Related, id)
+			excluded[id] = true
+		}
+
+		type candidate struct {
+			id        string
+			score     int
+			sameEvent bool
+		}
+		var candidates []candidate
+		for _, o := range codelabs {
+			if excluded[o.ID] || !o.IsPublished() {
+				continue
+			}
+			score := countShared(c.Tags, o.Tags) + countShared(c.Categories, o.Categories)
+			if score == 0 {
+				continue
+			}
+			candidates = append(candidates, candidate{
+				id:        o.ID,
+				score:     score,
+				sameEvent: countShared(codelabEvents(*c, events), codelabEvents(o, events)) > 0,
+			})
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].score != candidates[j].score {
+				return candidates[i].score > candidates[j].score
+			}
+			if candidates[i].sameEvent != candidates[j].sameEvent {
+				return candidates[i].sameEvent
+			}
+			return candidates[i].id < candidates[j].id
+		})
+		for _, cand := range candidates {
+			if len(c.Related) >= maxRelated {
+				break
+			}
+			c.Related = append(c.Related, cand.id)
+		}
+	}
+	return nil
+}
+
+// countShared returns the number of elements present in both a and b
+func countShared(a, b []string) int {
+	m := make(map[string]bool)
+	for _, e := range a {
+		m[e] = true
+	}
+	var n int
+	for _, e := range b {
+		if m[e] {
+			n++
+			// don't count duplicates in b twice
+			delete(m, e)
+		}
+	}
+	return n
+}
@@ -0,0 +1,135 @@
+package apis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestLinkRelated(t *testing.T) {
+	events := Events{"event-1": event{Name: "Event 1"}}
+
+	testCases := []struct {
+		name     string
+		codelabs []codelab.Codelab
+
+		wantRelated map[string][]string
+		wantErr     bool
+	}{
+		{"nothing shared",
+			[]codelab.Codelab{related("a", true, []string{"foo"}, nil), related("b", true, []string{"bar"}, nil)},
+			map[string][]string{"a": nil, "b": nil}, false},
+		{"shared tags",
+			[]codelab.Codelab{related("a", true, []string{"foo"}, nil), related("b", true, []string{"foo"}, nil)},
+			map[string][]string{"a": []string{"b"}, "b": []string{"a"}}, false},
+		{"shared categories",
+			[]codelab.Codelab{related("a", true, nil, []string{"snap"}), related("b", true, nil, []string{"snap"})},
+			map[string][]string{"a": []string{"b"}, "b": []string{"a"}}, false},
+		{"ranked by number of shared tags and categories",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo", "bar"}, []string{"snap"}),
+				related("b", true, []string{"foo"}, nil),
+				related("c", true, []string{"foo", "bar"}, []string{"snap"}),
+				related("d", true, []string{"bar"}, []string{"snap"}),
+			},
+			map[string][]string{"a": []string{"c", "d", "b"}, "b": []string{"a", "c"}, "c": []string{"a", "d", "b"}, "d": []string{"a", "c"}}, false},
+		{"events count as shared tags",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo", "event-1"}, nil),
+				related("b", true, []string{"foo"}, nil),
+				related("c", true, []string{"foo", "event-1"}, nil),
+			},
+			map[string][]string{"a": []string{"c", "b"}, "b": []string{"a", "c"}, "c": []string{"a", "b"}}, false},
+		{"same event breaks ties",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo", "event-1"}, nil),
+				related("b", true, []string{"foo"}, nil),
+				related("c", true, []string{"event-1"}, nil),
+			},
+			map[string][]string{"a": []string{"c", "b"}, "b": []string{"a"}, "c": []string{"a"}}, false},
+		{"limited number of related codelabs",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo"}, nil), related("b", true, []string{"foo"}, nil), related("c", true, []string{"foo"}, nil),
+				related("d", true, []string{"foo"}, nil), related("e", true, []string{"foo"}, nil),
+			},
+			map[string][]string{"a": []string{"b", "c", "d"}, "b": []string{"a", "c", "d"}, "c": []string{"a", "b", "d"},
+				"d": []string{"a", "b", "c"}, "e": []string{"a", "b", "c"}}, false},
+		{"drafts are excluded",
+			[]codelab.Codelab{related("a", true, []string{"foo"}, nil), related("b", false, []string{"foo"}, nil)},
+			map[string][]string{"a": nil, "b": []string{"a"}}, false},
+		{"pins are listed first",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo"}, nil, pins("c")),
+				related("b", true, []string{"foo"}, nil),
+				related("c", true, []string{"bar"}, nil),
+			},
+			map[string][]string{"a": []string{"c", "b"}, "b": []string{"a"}, "c": nil}, false},
+		{"pins can exceed limit",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo"}, nil, pins("e", "d", "c", "b")),
+				related("b", true, nil, nil), related("c", true, nil, nil), related("d", true, nil, nil), related("e", true, nil, nil),
+			},
+			map[string][]string{"a": []string{"e", "d", "c", "b"}, "b": nil, "c": nil, "d": nil, "e": nil}, false},
+		{"bans are never listed",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo"}, nil, bans("b")),
+				related("b", true, []string{"foo"}, nil),
+				related("c", true, []string{"foo"}, nil),
+			},
+			map[string][]string{"a": []string{"c"}, "b": []string{"a", "c"}, "c": []string{"a", "b"}}, false},
+		{"bans win over pins",
+			[]codelab.Codelab{
+				related("a", true, []string{"foo"}, nil, pins("b"), bans("b")),
+				related("b", true, []string{"foo"}, nil),
+			},
+			map[string][]string{"a": nil, "b": []string{"a"}}, false},
+		{"unknown pin",
+			[]codelab.Codelab{related("a", true, nil, nil, pins("doesnt-exist"))},
+			nil, true},
+		{"unpublished pin",
+			[]codelab.Codelab{related("a", true, nil, nil, pins("b")), related("b", false, nil, nil)},
+			nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("link related: %s", tc.name), func(t *testing.T) {
+			err := linkRelated(tc.codelabs, events)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("linkRelated() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			r := make(map[string][]string)
+			for _, c := range tc.codelabs {
+				r[c.ID] = c.Related
+			}
+			if !reflect.DeepEqual(r, tc.wantRelated) {
+				t.Errorf("got %+v; want %+v", r, tc.wantRelated)
+			}
+		})
+	}
+}
+
+type relatedOption func(*codelab.Codelab)
+
+func pins(ids ...string) relatedOption {
+	return func(c *codelab.Codelab) { c.RelatedPins = ids }
+}
+
+func bans(ids ...string) relatedOption {
+	return func(c *codelab.Codelab) { c.RelatedBans = ids }
+}
+
+func related(id string, published bool, tags, categories []string, opts ...relatedOption) codelab.Codelab {
+	c := newCodelab(id, published)
+	c.Tags = tags
+	c.Categories = categories
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
@@ -15,13 +15,24 @@ const apiFileName = "codelabs.json"
 
 // site API main info
 type site struct {
-	Categories Categories        `json:"categories"`
-	Codelabs   []codelab.Codelab `json:"codelabs"`
-	Events     Events            `json:"events"`
+	Categories  Categories        `json:"categories"`
+	Codelabs    []codelab.Codelab `json:"codelabs"`
+	Collections Collections       `json:"collections"`
+	Events      Events            `json:"events"`
+	Facets      facets            `json:"facets"`
 }
 
 // GenerateContent for website api, preparing and saving event images already
 func GenerateContent(c []codelab.Codelab) ([]byte, error) {
+	// work on a copy, with one element per codelab, as we are linking codelabs between them
+	codelabs, err := groupVariants(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := linkPrerequisites(codelabs); err != nil {
+		return nil, err
+	}
+
 	e, err := NewEvents()
 	if err != nil {
 		return nil, err
@@ -29,15 +40,24 @@ func GenerateContent(c []codelab.Codelab) ([]byte, error) {
 	if err := e.SaveImages(); err != nil {
 		return nil, err
 	}
+	if err := linkRelated(codelabs, *e); err != nil {
+		return nil, err
+	}
 	cat, err := NewCategories()
 	if err != nil {
 		return nil, err
 	}
+	col, err := NewCollections(codelabs)
+	if err != nil {
+		return nil, err
+	}
 
 	s := site{
-		Categories: *cat,
-		Codelabs:   c,
-		Events:     *e,
+		Categories:  *cat,
+		Codelabs:    codelabs,
+		Collections: *col,
+		Events:      *e,
+		Facets:      newFacets(codelabs, *e),
 	}
 	return json.MarshalIndent(s, "", "  ")
 }
@@ -17,6 +17,7 @@
 package claattools
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -49,6 +50,18 @@ type Resource struct {
 // driveAPI is a base URL for Drive API
 const driveAPI = "https://www.googleapis.com/drive/v3"
 
+// PollTimeout bounds requests checking if remote files changed, for pollers not to be stuck on stalled servers
+var PollTimeout = 30 * time.Second
+
+// RateLimitError is returned when a request was refused as we are sending too many
+type RateLimitError struct {
+	URL string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: rate limit exceeded", e.URL)
+}
+
 // Fetch retrieves codelab doc either from local disk
 // or a remote location.
 // The caller is responsible for closing returned stream.
@@ -81,7 +94,7 @@ func FetchRemote(urlStr string, nometa bool) (*Resource, error) {
 	if err != nil {
 		return nil, err
 	}
-	if (u.Host == "" && strings.HasPrefix(urlStr, consts.GdocPrefix)) || u.Host == "docs.google.com" {
+	if IsGdoc(urlStr) {
 		return fetchDriveFile(strings.TrimPrefix(urlStr, consts.GdocPrefix), nometa)
 	}
 	// If there is still no host, not a gdoc neither a local existing path, fail immediately
@@ -91,6 +104,102 @@ func FetchRemote(urlStr string, nometa bool) (*Resource, error) {
 	return fetchRemoteFile(urlStr)
 }
 
+// IsGdoc returns true if a codelab reference or url is a Google Doc: a Google Doc ID prepended by gdoc:
+// or a Google Docs URL.
+func IsGdoc(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	return (u.Host == "" && strings.HasPrefix(urlStr, consts.GdocPrefix)) || u.Host == "docs.google.com"
+}
+
+// DriveModifiedTime returns the last modification time of a Google Doc, given as for FetchRemote.
+// It's only requested once, for at most PollTimeout: a RateLimitError is returned if we are sending too many
+// requests, for callers to back off.
+func DriveModifiedTime(urlStr string) (time.Time, error) {
+	client, err := DriveClient()
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the drive client is shared with fetches of whole docs, which can take longer
+	c := *client
+	c.Timeout = PollTimeout
+	u := fmt.Sprintf("%s/files/%s?fields=modifiedTime", driveAPI, gdocID(strings.TrimPrefix(urlStr, consts.GdocPrefix)))
+	res, err := c.Get(u)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		if rateLimited(res.StatusCode, b) {
+			return time.Time{}, &RateLimitError{URL: u}
+		}
+		return time.Time{}, fmt.Errorf("fetch %s: %s; %s", u, res.Status, b)
+	}
+	meta := &struct {
+		Modified time.Time `json:"modifiedTime"`
+	}{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return time.Time{}, err
+	}
+	return meta.Modified, nil
+}
+
+// RemoteVersion identifies the content of a remote file by its hash, with cache validators to only
+// download it again if it changed.
+type RemoteVersion struct {
+	ETag         string
+	LastModified string
+	Hash         string
+}
+
+// CheckRemote requests a remote file, only if it changed since its known version, given by last.
+// It returns the current version of the file, equal to last if the server didn't send it again. Its content
+// changed if the hash is different: servers may send the same content again with other validators, or none.
+// A RateLimitError is returned if we are sending too many requests, for callers to back off. The request is
+// abandoned after PollTimeout.
+func CheckRemote(urlStr string, last RemoteVersion) (RemoteVersion, error) {
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return last, err
+	}
+	if last.ETag != "" {
+		req.Header.Set("If-None-Match", last.ETag)
+	}
+	if last.LastModified != "" {
+		req.Header.Set("If-Modified-Since", last.LastModified)
+	}
+	c := &http.Client{Timeout: PollTimeout}
+	res, err := c.Do(req)
+	if err != nil {
+		return last, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return last, nil
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return last, err
+	}
+	if res.StatusCode != http.StatusOK {
+		if rateLimited(res.StatusCode, b) {
+			return last, &RateLimitError{URL: urlStr}
+		}
+		return last, fmt.Errorf("fetch %s: %s", urlStr, res.Status)
+	}
+	return RemoteVersion{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Hash:         fmt.Sprintf("%x", sha256.Sum256(b)),
+	}, nil
+}
+
 // fetchRemoteFile retrieves codelab resource from url.
 // It is a special case of fetchRemote function.
 func fetchRemoteFile(url string) (*Resource, error) {
@@ -192,31 +301,38 @@ func retryGet(client *http.Client, url string, n int) (*http.Response, error) {
 		if err != nil {
 			continue
 		}
-		// otherwise, decode error response and check for "rate limit"
+		// otherwise, check for "rate limit"
 		defer res.Body.Close()
-		var erres struct {
-			Error struct {
-				Errors []struct{ Reason string }
-			}
-		}
 		b, _ := ioutil.ReadAll(res.Body)
-		json.Unmarshal(b, &erres)
-		var rateLimit bool
-		for _, e := range erres.Error.Errors {
-			if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
-				rateLimit = true
-				break
-			}
-		}
 		// this is neither a rate limit error, nor a server error:
 		// retrying is useless
-		if !rateLimit && res.StatusCode < http.StatusInternalServerError {
+		if !rateLimited(res.StatusCode, b) && res.StatusCode < http.StatusInternalServerError {
 			return nil, fmt.Errorf("fetch %s: %s; %s", url, res.Status, b)
 		}
 	}
 	return nil, fmt.Errorf("%s: failed after %d retries", url, n)
 }
 
+// rateLimited returns true if an error response, with its body, is refusing a request for sending too many
+func rateLimited(status int, body []byte) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	// decode error response
+	var erres struct {
+		Error struct {
+			Errors []struct{ Reason string }
+		}
+	}
+	json.Unmarshal(body, &erres)
+	for _, e := range erres.Error.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
 func gdocID(url string) string {
 	const s = "/document/d/"
 	if i := strings.Index(url, s); i >= 0 {
@@ -17,11 +17,14 @@
 package claattools
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type testTransport struct {
@@ -95,6 +98,63 @@ func TestFetchRemoteDrive(t *testing.T) {
 	}
 }
 
+func TestDriveModifiedTime(t *testing.T) {
+	tests := []struct {
+		ref    string
+		status int
+		body   string
+
+		want          time.Time
+		wantRateLimit bool
+		wantErr       bool
+	}{
+		{"gdoc:doc-123", http.StatusOK, `{"modifiedTime": "2017-05-04T10:20:30.000Z"}`, time.Date(2017, 5, 4, 10, 20, 30, 0, time.UTC), false, false},
+		{"https://docs.google.com/document/d/doc-123/edit", http.StatusOK, `{"modifiedTime": "2017-05-04T10:20:30.000Z"}`, time.Date(2017, 5, 4, 10, 20, 30, 0, time.UTC), false, false},
+		{"gdoc:doc-123", http.StatusForbidden, `{"error": {"errors": [{"reason": "userRateLimitExceeded"}]}}`, time.Time{}, true, true},
+		{"gdoc:doc-123", http.StatusTooManyRequests, ``, time.Time{}, true, true},
+		{"gdoc:doc-123", http.StatusForbidden, `{"error": {"errors": [{"reason": "forbidden"}]}}`, time.Time{}, false, true},
+		{"gdoc:doc-123", http.StatusOK, `not json`, time.Time{}, false, true},
+	}
+	for i, test := range tests {
+		rt := &testTransport{func(r *http.Request) (*http.Response, error) {
+			if !strings.HasSuffix(r.URL.Path, "/files/doc-123") || r.URL.Query().Get("fields") != "modifiedTime" {
+				t.Errorf("%d: r.URL = %q; want modifiedTime of /files/doc-123", i, r.URL)
+			}
+			return &http.Response{Body: ioutil.NopCloser(strings.NewReader(test.body)), StatusCode: test.status}, nil
+		}}
+		clients[providerGoogle] = &http.Client{Transport: rt}
+
+		got, err := DriveModifiedTime(test.ref)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%d: DriveModifiedTime(%q) error = %v; want error: %v", i, test.ref, err, test.wantErr)
+		}
+		if _, ok := err.(*RateLimitError); ok != test.wantRateLimit {
+			t.Errorf("%d: DriveModifiedTime(%q) error = %v; want rate limit: %v", i, test.ref, err, test.wantRateLimit)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%d: DriveModifiedTime(%q) = %v; want %v", i, test.ref, got, test.want)
+		}
+	}
+}
+
+func TestIsGdoc(t *testing.T) {
+	tests := []struct {
+		in  string
+		out bool
+	}{
+		{"gdoc:foo", true},
+		{"https://docs.google.com/document/d/foo/edit", true},
+		{"https://example.com/gdoc:foo", false},
+		{"foo.md", false},
+		{"gdoc.def", false},
+	}
+	for i, test := range tests {
+		if out := IsGdoc(test.in); out != test.out {
+			t.Errorf("%d: IsGdoc(%q) = %v; want %v", i, test.in, out, test.out)
+		}
+	}
+}
+
 func TestGdocID(t *testing.T) {
 	tests := []struct{ in, out string }{
 		{"https://docs.google.com/document/d/foo", "foo"},
@@ -110,3 +170,108 @@ func TestGdocID(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckRemote(t *testing.T) {
+	const (
+		etag         = `"v1"`
+		lastModified = "Thu, 04 May 2017 10:20:30 GMT"
+		body         = "test"
+	)
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	tests := []struct {
+		last    RemoteVersion
+		etag    string
+		lastMod string
+		status  int
+
+		wantRequestETag    string
+		wantRequestLastMod string
+		want               RemoteVersion
+		wantRateLimit      bool
+		wantErr            bool
+	}{
+		// first request, without validators
+		{RemoteVersion{}, etag, lastModified, http.StatusOK, "", "", RemoteVersion{etag, lastModified, hash}, false, false},
+		// not modified
+		{RemoteVersion{etag, lastModified, hash}, etag, lastModified, http.StatusNotModified, etag, lastModified, RemoteVersion{etag, lastModified, hash}, false, false},
+		// server without validators sending same content
+		{RemoteVersion{"", "", hash}, "", "", http.StatusOK, "", "", RemoteVersion{"", "", hash}, false, false},
+		// modified
+		{RemoteVersion{`"v0"`, "", "old"}, etag, "", http.StatusOK, `"v0"`, "", RemoteVersion{etag, "", hash}, false, false},
+		{RemoteVersion{etag, "", hash}, "", "", http.StatusTooManyRequests, etag, "", RemoteVersion{etag, "", hash}, true, true},
+		{RemoteVersion{etag, "", hash}, "", "", http.StatusNotFound, etag, "", RemoteVersion{etag, "", hash}, false, true},
+	}
+	for i, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v := r.Header.Get("If-None-Match"); v != test.wantRequestETag {
+				t.Errorf("%d: If-None-Match = %q; want %q", i, v, test.wantRequestETag)
+			}
+			if v := r.Header.Get("If-Modified-Since"); v != test.wantRequestLastMod {
+				t.Errorf("%d: If-Modified-Since = %q; want %q", i, v, test.wantRequestLastMod)
+			}
+			if test.etag != "" {
+				w.Header().Set("ETag", test.etag)
+			}
+			if test.lastMod != "" {
+				w.Header().Set("Last-Modified", test.lastMod)
+			}
+			w.WriteHeader(test.status)
+			if test.status == http.StatusOK {
+				w.Write([]byte(body))
+			}
+		}))
+
+		got, err := CheckRemote(ts.URL, test.last)
+		ts.Close()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%d: CheckRemote error = %v; want error: %v", i, err, test.wantErr)
+		}
+		if _, ok := err.(*RateLimitError); ok != test.wantRateLimit {
+			t.Errorf("%d: CheckRemote error = %v; want rate limit: %v", i, err, test.wantRateLimit)
+		}
+		if got != test.want {
+			t.Errorf("%d: CheckRemote = %+v; want %+v", i, got, test.want)
+		}
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { PollTimeout = timeout }(PollTimeout)
+	PollTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer ts.Close()
+	defer close(release)
+	// stalls until the request is abandoned
+	clients[providerGoogle] = &http.Client{Transport: &testTransport{func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}}}
+
+	tests := []struct {
+		name  string
+		check func() error
+	}{
+		{"CheckRemote", func() error {
+			_, err := CheckRemote(ts.URL, RemoteVersion{})
+			return err
+		}},
+		{"DriveModifiedTime", func() error {
+			_, err := DriveModifiedTime("gdoc:doc-123")
+			return err
+		}},
+	}
+	for _, test := range tests {
+		done := make(chan error)
+		go func() { done <- test.check() }()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%s on a stalled server: got no error; want a timeout", test.name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s on a stalled server didn't time out", test.name)
+		}
+	}
+}
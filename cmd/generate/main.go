@@ -16,6 +16,7 @@ import (
 )
 
 func main() {
+	graph := flag.String("graph", "", "export codelab prerequisites graph in DOT format to this file")
 	flag.Usage = usage
 	flag.Parse()
 	args := internaltools.UniqueStrings(flag.Args())
@@ -78,6 +79,20 @@ func main() {
 	if err := apis.Save(dat); err != nil {
 		log.Fatalf("Couldn't save API: %s", err)
 	}
+
+	if *graph != "" {
+		f, err := os.Create(*graph)
+		if err != nil {
+			log.Fatalf("Couldn't create %s: %v", *graph, err)
+		}
+		if err := apis.WriteDependencyGraph(f, codelabs); err != nil {
+			f.Close()
+			log.Fatalf("Couldn't export prerequisites graph: %s", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Couldn't write %s: %v", *graph, err)
+		}
+	}
 }
 
 func usage() {
@@ -85,12 +100,15 @@ func usage() {
 	fmt.Fprintf(os.Stderr, `Generate tutorials in html, using Polymerjs and its API.
 
 It fetches in well known places the codelab list and sources (both in google
-doc or markdown format), the general events and categories metadata, to generate
+doc or markdown format), the general events, categories and collections metadata, to generate
 the desired output and API files.
 
 Every default directories will be detected by the tool if present in the tutorial
 directories. Arguments and options can tweak this behavior.
 
+Prerequisites, related and unrelated codelabs and language are declared in the
+header of markdown codelabs. Google doc codelabs can't declare them.
+
 `)
 	flag.PrintDefaults()
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+const (
+	// apiStage is the stage of API generation errors, on top of codelab build stages
+	apiStage = "api"
+	// apiErrorRef is where API generation error is stored among codelab references
+	apiErrorRef = "<api>"
+)
+
+// setBuildError records the error of the last build of ref. c can be nil if the codelab was never built.
+// It returns the failing codelab name, as reported to browsers.
+func (ws *workspace) setBuildError(ref string, c *codelab.Codelab, err error) string {
+	e := websocket.BuildError{Codelab: ref, File: ref, Stage: "build", Message: err.Error()}
+	if c != nil && c.ID != "" {
+		e.Codelab = c.ID
+	}
+	if be, ok := err.(*codelab.BuildError); ok {
+		e.File = be.File
+		e.Stage = be.Stage
+		e.Message = be.Err.Error()
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.errors[ref] = e
+	return e.Codelab
+}
+
+// setAPIError records the error of the last API generation
+func (ws *workspace) setAPIError(metadataDir string, err error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.errors[apiErrorRef] = websocket.BuildError{File: metadataDir, Stage: apiStage, Message: err.Error()}
+}
+
+// clearBuildError forgets any error of ref (or the API one) once it builds successfully.
+func (ws *workspace) clearBuildError(ref string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.errors, ref)
+}
+
+// forgetBuildErrors removes errors of codelab references which aren't discovered anymore
+func (ws *workspace) forgetBuildErrors(discovered map[string]bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for ref := range ws.errors {
+		if ref != apiErrorRef && !discovered[ref] {
+			delete(ws.errors, ref)
+		}
+	}
+}
+
+// buildErrors returns all build errors, ordered by codelab reference
+func (ws *workspace) buildErrors() []websocket.BuildError {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	var refs []string
+	for ref := range ws.errors {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	var errs []websocket.BuildError
+	for _, ref := range refs {
+		errs = append(errs, ws.errors[ref])
+	}
+	return errs
+}
+
+// buildStatusMessage returns a build result message for codelabs, listing all current errors, including
+// the ones of other codelabs.
+func (ws *workspace) buildStatusMessage(failed bool, codelabs ...string) websocket.Message {
+	t := websocket.BuildSucceededMessage
+	if failed {
+		t = websocket.BuildFailedMessage
+	}
+	m := websocket.NewMessage(t, codelabs...)
+	m.Errors = ws.buildErrors()
+	return m
+}
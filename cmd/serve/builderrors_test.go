@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+func TestBuildErrorsReport(t *testing.T) {
+	ws := newWorkspace("", nil)
+
+	if name := ws.setBuildError("b.md", nil, errors.New("b failed")); name != "b.md" {
+		t.Errorf("got failing codelab name %q; want %q", name, "b.md")
+	}
+	ws.setBuildError("a.md", &codelab.Codelab{}, &codelab.BuildError{Stage: codelab.AssetsStage, File: "foo.png", Err: errors.New("a failed")})
+	ws.setAPIError("metadata", errors.New("api failed"))
+	want := []websocket.BuildError{
+		{Codelab: "", File: "metadata", Stage: apiStage, Message: "api failed"},
+		{Codelab: "a.md", File: "foo.png", Stage: codelab.AssetsStage, Message: "a failed"},
+		{Codelab: "b.md", File: "b.md", Stage: "build", Message: "b failed"},
+	}
+	assertBuildErrors(t, ws, want)
+
+	// codelab ID is used when known
+	c := &codelab.Codelab{}
+	c.ID = "codelab-b"
+	if name := ws.setBuildError("b.md", c, errors.New("b failed again")); name != "codelab-b" {
+		t.Errorf("got failing codelab name %q; want %q", name, "codelab-b")
+	}
+	want[2] = websocket.BuildError{Codelab: "codelab-b", File: "b.md", Stage: "build", Message: "b failed again"}
+	assertBuildErrors(t, ws, want)
+
+	// removed codelabs don't report errors anymore, while API does
+	ws.forgetBuildErrors(map[string]bool{"b.md": true})
+	want = []websocket.BuildError{want[0], want[2]}
+	assertBuildErrors(t, ws, want)
+
+	// fixed builds are clearing errors
+	ws.clearBuildError("b.md")
+	ws.clearBuildError(apiErrorRef)
+	assertBuildErrors(t, ws, nil)
+}
+
+func TestBuildStatusMessage(t *testing.T) {
+	ws := newWorkspace("", nil)
+	ws.setBuildError("a.md", nil, errors.New("a failed"))
+	wantErrors := []websocket.BuildError{{Codelab: "a.md", File: "a.md", Stage: "build", Message: "a failed"}}
+
+	testCases := []struct {
+		failed bool
+
+		wantType string
+	}{
+		{true, websocket.BuildFailedMessage},
+		// other codelabs errors are still listed
+		{false, websocket.BuildSucceededMessage},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("build failed: %v", tc.failed), func(t *testing.T) {
+			m := ws.buildStatusMessage(tc.failed, "b")
+
+			if m.Type != tc.wantType {
+				t.Errorf("got message type %q; want %q", m.Type, tc.wantType)
+			}
+			if !reflect.DeepEqual(m.Codelabs, []string{"b"}) {
+				t.Errorf("got codelabs %+v; want %+v", m.Codelabs, []string{"b"})
+			}
+			if !reflect.DeepEqual(m.Errors, wantErrors) {
+				t.Errorf("got errors %+v; want %+v", m.Errors, wantErrors)
+			}
+		})
+	}
+}
+
+func assertBuildErrors(t *testing.T, ws *workspace, want []websocket.BuildError) {
+	if got := ws.buildErrors(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// Control endpoints, only accepting posted requests from allowed origins with the shared token. Without
+// any token, they are only available to this machine.
+const (
+	controlURL           = "/_control/"
+	controlRebuildURL    = controlURL + "rebuild"     // rebuild codelabs given by ID or reference in codelab parameters
+	controlRebuildAllURL = controlURL + "rebuild-all" // rebuild every codelab and the API
+	controlRediscoverURL = controlURL + "rediscover"  // look for new or removed codelabs
+	controlRefetchURL    = controlURL + "refetch"     // rebuild codelabs with google docs or remote files
+)
+
+// controlResponse lists the rebuild targets which were scheduled
+type controlResponse struct {
+	Scheduled []string `json:"scheduled"`
+}
+
+// controlHandler serves the control endpoints, scheduling rebuilds for editors and scripts.
+// This is how changes to google docs and remote files, which aren't watched, can be fetched again.
+func (ws *workspace) controlHandler(s *rebuildScheduler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(controlRebuildURL, func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["codelab"]
+		if len(names) == 0 {
+			http.Error(w, "At least one codelab parameter is required", http.StatusBadRequest)
+			return
+		}
+		var refs []string
+		for _, name := range names {
+			found := ws.codelabRefs(name)
+			if len(found) == 0 {
+				http.Error(w, fmt.Sprintf("Unknown codelab %q", name), http.StatusNotFound)
+				return
+			}
+			refs = append(refs, found...)
+		}
+		schedule(w, s, refs...)
+	})
+	mux.HandleFunc(controlRebuildAllURL, func(w http.ResponseWriter, r *http.Request) {
+		schedule(w, s, allTarget)
+	})
+	mux.HandleFunc(controlRediscoverURL, func(w http.ResponseWriter, r *http.Request) {
+		schedule(w, s, rediscoverTarget)
+	})
+	mux.HandleFunc(controlRefetchURL, func(w http.ResponseWriter, r *http.Request) {
+		schedule(w, s, ws.remoteRefs()...)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if reloadToken == "" && !isLoopback(r) {
+			http.Error(w, "Control endpoints are only available from this machine without a token", http.StatusForbidden)
+			return
+		}
+		// other websites opened in the browser could otherwise trigger rebuilds
+		if !hub.Accept(w, r) {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback returns if the request comes from this machine
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// schedule rebuilds targets, if any, and replies with them
+func schedule(w http.ResponseWriter, s *rebuildScheduler, targets ...string) {
+	if len(targets) > 0 {
+		s.schedule(targets...)
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	// special targets are readable in terminals
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(controlResponse{Scheduled: append([]string{}, targets...)}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(b.Bytes())
+}
+
+// codelabRefs returns the references of a served or failing codelab, given by reference or ID.
+// Every language variant of a codelab shares the same ID.
+func (ws *workspace) codelabRefs(name string) []string {
+	if name == "" || name == apiErrorRef {
+		return nil
+	}
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	found := make(map[string]bool)
+	for _, c := range ws.all() {
+		if c.RefURI == name {
+			return []string{name}
+		}
+		if c.ID == name {
+			found[c.RefURI] = true
+		}
+	}
+	if _, ok := ws.errors[name]; ok {
+		return []string{name}
+	}
+	for ref, e := range ws.errors {
+		if ref != apiErrorRef && e.Codelab == name {
+			found[ref] = true
+		}
+	}
+	return sortedKeys(found)
+}
+
+// remoteRefs returns the references of codelabs which are google docs or using remote files,
+// including failing google docs
+func (ws *workspace) remoteRefs() []string {
+	found := make(map[string]bool)
+	for _, refs := range ws.remoteFiles(func(string) bool { return true }) {
+		for _, ref := range refs {
+			found[ref] = true
+		}
+	}
+	return sortedKeys(found)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/consts"
+)
+
+func TestControlHandler(t *testing.T) {
+	defer func(token string) {
+		reloadToken = token
+		hub.SetToken(token)
+	}(reloadToken)
+	reloadToken = "secret"
+	hub.SetToken("secret")
+
+	a := codelab.Codelab{RefURI: "a.md"}
+	a.ID = "codelab-a"
+	aFr := codelab.Codelab{RefURI: "a.fr.md"}
+	aFr.ID = "codelab-a"
+	remote := codelab.Codelab{RefURI: "remote.md", RemoteFiles: []string{"https://example.com/foo.png"}}
+	remote.ID = "codelab-remote"
+	gdoc := codelab.Codelab{RefURI: consts.GdocPrefix + "doc"}
+	gdoc.ID = "codelab-gdoc"
+	ws := newWorkspace("", nil)
+	ws.setBuildError("failing.md", nil, errors.New("failing"))
+	ws.setBuildError(consts.GdocPrefix+"failing", nil, errors.New("failing"))
+	ws.setAPIError("metadata", errors.New("api failed"))
+	ws.update([]codelab.Codelab{a, aFr, remote, gdoc}, nil)
+
+	testCases := []struct {
+		method string
+		url    string
+		token  string
+
+		wantStatus    int
+		wantScheduled []string
+	}{
+		{"POST", controlRebuildURL + "?codelab=a.md", "secret", http.StatusAccepted, []string{"a.md"}},
+		{"POST", controlRebuildURL + "?codelab=codelab-a", "secret", http.StatusAccepted, []string{"a.fr.md", "a.md"}},
+		{"POST", controlRebuildURL + "?codelab=a.md&codelab=codelab-remote", "secret", http.StatusAccepted, []string{"a.md", "remote.md"}},
+		{"POST", controlRebuildURL + "?codelab=failing.md", "secret", http.StatusAccepted, []string{"failing.md"}},
+		{"POST", controlRebuildURL + "?codelab=unknown", "secret", http.StatusNotFound, nil},
+		{"POST", controlRebuildURL + "?codelab=" + apiErrorRef, "secret", http.StatusNotFound, nil},
+		{"POST", controlRebuildURL, "secret", http.StatusBadRequest, nil},
+		{"POST", controlRebuildAllURL, "secret", http.StatusAccepted, []string{allTarget}},
+		{"POST", controlRediscoverURL, "secret", http.StatusAccepted, []string{rediscoverTarget}},
+		{"POST", controlRefetchURL, "secret", http.StatusAccepted, []string{consts.GdocPrefix + "doc", consts.GdocPrefix + "failing", "remote.md"}},
+		{"POST", controlURL + "unknown", "secret", http.StatusNotFound, nil},
+		{"POST", controlRebuildAllURL, "", http.StatusUnauthorized, nil},
+		{"POST", controlRebuildAllURL, "other", http.StatusUnauthorized, nil},
+		{"GET", controlRebuildAllURL, "secret", http.StatusMethodNotAllowed, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s with token %q", tc.method, tc.url, tc.token), func(t *testing.T) {
+			calls, s, stop := createScheduler(t, nil)
+			defer stop()
+
+			r := httptest.NewRequest(tc.method, tc.url, nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			ws.controlHandler(s).ServeHTTP(rec, r)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("got status %d; want %d", rec.Code, tc.wantStatus)
+			}
+			if tc.wantScheduled == nil {
+				noCall(t, calls)
+				return
+			}
+			var res controlResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("Couldn't decode response %q: %v", rec.Body.String(), err)
+			}
+			if !reflect.DeepEqual(res.Scheduled, tc.wantScheduled) {
+				t.Errorf("got %v scheduled; want %v", res.Scheduled, tc.wantScheduled)
+			}
+			wantCall(t, calls, tc.wantScheduled)
+		})
+	}
+}
+
+func TestControlHandlerPeers(t *testing.T) {
+	defer func(token string) {
+		reloadToken = token
+		hub.SetToken(token)
+	}(reloadToken)
+	ws := newWorkspace("", nil)
+
+	testCases := []struct {
+		token      string
+		remoteAddr string
+		origin     string
+
+		wantStatus int
+	}{
+		{"secret", "192.0.2.1:1234", "", http.StatusAccepted},
+		{"secret", "192.0.2.1:1234", "http://example.com", http.StatusAccepted}, // same origin as the served host
+		{"secret", "192.0.2.1:1234", "http://evil.com", http.StatusForbidden},
+		{"", "127.0.0.1:1234", "", http.StatusAccepted},
+		{"", "[::1]:1234", "", http.StatusAccepted},
+		{"", "127.0.0.1:1234", "http://localhost:8081", http.StatusForbidden},
+		{"", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"", "invalid", "", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("token %q from %s with origin %q", tc.token, tc.remoteAddr, tc.origin), func(t *testing.T) {
+			reloadToken = tc.token
+			hub.SetToken(tc.token)
+			calls, s, stop := createScheduler(t, nil)
+			defer stop()
+
+			r := httptest.NewRequest("POST", "http://example.com"+controlRebuildAllURL, nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			ws.controlHandler(s).ServeHTTP(rec, r)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("got status %d; want %d", rec.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusAccepted {
+				noCall(t, calls)
+				return
+			}
+			wantCall(t, calls, []string{allTarget})
+		})
+	}
+}
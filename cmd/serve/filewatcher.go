@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	notifyWatchMode = "notify"
+	pollWatchMode   = "poll"
+)
+
+// fileWatcher notifies about changes of files in watched directories
+type fileWatcher interface {
+	Add(dir string) error
+	Remove(dir string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// newFileWatcher returns a file watcher backend corresponding to mode.
+// interval is only used when polling.
+func newFileWatcher(mode string, interval time.Duration) (fileWatcher, error) {
+	switch mode {
+	case notifyWatchMode:
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, err
+		}
+		return &notifyWatcher{w}, nil
+	case pollWatchMode:
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid polling interval: %v", interval)
+		}
+		return newPollWatcher(interval), nil
+	}
+	return nil, fmt.Errorf("unknown watch mode: %q. Supported modes are %q and %q", mode, notifyWatchMode, pollWatchMode)
+}
+
+// notifyWatcher is using file system notifications, like inotify
+type notifyWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func (n *notifyWatcher) Add(dir string) error          { return n.w.Add(dir) }
+func (n *notifyWatcher) Remove(dir string) error       { return n.w.Remove(dir) }
+func (n *notifyWatcher) Events() <-chan fsnotify.Event { return n.w.Events }
+func (n *notifyWatcher) Errors() <-chan error          { return n.w.Errors }
+func (n *notifyWatcher) Close() error                  { return n.w.Close() }
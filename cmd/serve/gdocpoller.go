@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/claattools"
+)
+
+// gdocPoller polls the modification time of google docs, which can't be watched, and schedules rebuilds
+// of codelabs using changed ones.
+type gdocPoller struct {
+	filePoller
+	ws           *workspace
+	modifiedTime func(doc string) (time.Time, error)
+
+	modified map[string]time.Time // last known modification time per google doc
+}
+
+func newGdocPoller(ws *workspace, schedule func(targets ...string)) *gdocPoller {
+	return &gdocPoller{
+		filePoller:   newFilePoller(schedule),
+		ws:           ws,
+		modifiedTime: claattools.DriveModifiedTime,
+		modified:     make(map[string]time.Time),
+	}
+}
+
+// pollGdocs starts polling google docs every interval until stop is closed
+func pollGdocs(wg *sync.WaitGroup, stop <-chan struct{}, ws *workspace, s *rebuildScheduler, interval time.Duration) {
+	runPoller(wg, stop, newGdocPoller(ws, s.schedule), interval, "google docs")
+}
+
+// poll checks every google doc once and schedules rebuilds of codelabs using modified ones.
+// The first time a doc is polled, it's compared to when codelabs using it were built, as it could have been
+// edited after they fetched it.
+// It stops at the first request refused for rate limit, returning true.
+func (p *gdocPoller) poll() (limited bool) {
+	docs := p.ws.remoteFiles(claattools.IsGdoc)
+	limited = p.pollFiles(docs, func(doc string) (bool, error) {
+		t, err := p.modifiedTime(doc)
+		if err != nil {
+			return false, err
+		}
+		last, ok := p.modified[doc]
+		if !ok {
+			last, ok = p.ws.oldestBuild(docs[doc])
+		}
+		p.modified[doc] = t
+		return ok && t.After(last), nil
+	})
+
+	// forget docs which aren't used anymore
+	for doc := range p.modified {
+		if _, ok := docs[doc]; !ok {
+			delete(p.modified, doc)
+		}
+	}
+	return limited
+}
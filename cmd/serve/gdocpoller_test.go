@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/claattools"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestGdocPoll(t *testing.T) {
+	ws := newWorkspace("", nil)
+	doc := codelab.Codelab{RefURI: "gdoc:doc"}
+	withImport := codelab.Codelab{RefURI: "a.md", RemoteFiles: []string{"https://docs.google.com/document/d/imported/edit", "https://example.com/a.png"}}
+	ws.update([]codelab.Codelab{doc, withImport}, nil)
+
+	now := time.Now()
+	var scheduled [][]string
+	modified := map[string]time.Time{"gdoc:doc": now, "https://docs.google.com/document/d/imported/edit": now}
+	var pollErr error
+	p := newGdocPoller(ws, func(targets ...string) { scheduled = append(scheduled, targets) })
+	p.modifiedTime = func(doc string) (time.Time, error) {
+		if pollErr != nil {
+			return time.Time{}, pollErr
+		}
+		m, ok := modified[doc]
+		if !ok {
+			t.Errorf("Unexpected poll of %s", doc)
+		}
+		return m, nil
+	}
+
+	testCases := []struct {
+		name    string
+		changed []string
+		err     error
+
+		wantLimited   bool
+		wantScheduled []string
+	}{
+		{"first poll only records times", nil, nil, false, nil},
+		{"nothing changed", nil, nil, false, nil},
+		{"doc changed", []string{"gdoc:doc"}, nil, false, []string{"gdoc:doc"}},
+		{"imported doc changed", []string{"https://docs.google.com/document/d/imported/edit"}, nil, false, []string{"a.md"}},
+		{"both changed", []string{"gdoc:doc", "https://docs.google.com/document/d/imported/edit"}, nil, false, []string{"gdoc:doc", "a.md"}},
+		{"rate limited", []string{"gdoc:doc"}, &claattools.RateLimitError{URL: "drive"}, true, nil},
+		{"changed while rate limited", nil, nil, false, []string{"gdoc:doc"}},
+		{"failing doc", []string{"gdoc:doc"}, errors.New("failing"), false, nil},
+		{"changed while failing", nil, nil, false, []string{"gdoc:doc"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheduled = nil
+			for _, doc := range tc.changed {
+				modified[doc] = modified[doc].Add(time.Second)
+			}
+			pollErr = tc.err
+
+			if limited := p.poll(); limited != tc.wantLimited {
+				t.Errorf("got rate limited: %v; want %v", limited, tc.wantLimited)
+			}
+			var want [][]string
+			if tc.wantScheduled != nil {
+				want = [][]string{tc.wantScheduled}
+			}
+			if !reflect.DeepEqual(scheduled, want) {
+				t.Errorf("got %v scheduled; want %v", scheduled, want)
+			}
+		})
+	}
+
+	// removed docs are forgotten
+	ws.update(nil, nil)
+	p.poll()
+	if len(p.modified) != 0 {
+		t.Errorf("got %v modification times; want removed docs to be forgotten", p.modified)
+	}
+}
+
+func TestGdocFirstPoll(t *testing.T) {
+	built := time.Now()
+
+	testCases := []struct {
+		name     string
+		builds   map[string]time.Time
+		modified time.Time
+
+		wantScheduled bool
+	}{
+		{"modified before build", map[string]time.Time{"gdoc:doc": built, "a.md": built}, built.Add(-time.Second), false},
+		{"modified after build", map[string]time.Time{"gdoc:doc": built, "a.md": built}, built.Add(time.Second), true},
+		{"modified after oldest build", map[string]time.Time{"gdoc:doc": built.Add(2 * time.Second), "a.md": built}, built.Add(time.Second), true},
+		{"never built", nil, built.Add(time.Second), false},
+		{"not every codelab built", map[string]time.Time{"gdoc:doc": built}, built.Add(time.Second), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := newWorkspace("", nil)
+			ws.update([]codelab.Codelab{{RefURI: "gdoc:doc"}, {RefURI: "a.md", RemoteFiles: []string{"gdoc:doc"}}}, nil)
+			for ref, at := range tc.builds {
+				ws.recordBuild(ref, at)
+			}
+			var scheduled []string
+			p := newGdocPoller(ws, func(targets ...string) { scheduled = append(scheduled, targets...) })
+			p.modifiedTime = func(doc string) (time.Time, error) { return tc.modified, nil }
+
+			p.poll()
+
+			var want []string
+			if tc.wantScheduled {
+				want = []string{"a.md", "gdoc:doc"}
+			}
+			if !reflect.DeepEqual(scheduled, want) {
+				t.Errorf("got %v scheduled; want %v", scheduled, want)
+			}
+		})
+	}
+}
@@ -7,27 +7,61 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"strings"
+	"time"
 
 	"sync"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/ubuntu/tutorial-deployment/apis"
 	"github.com/ubuntu/tutorial-deployment/codelab"
 	"github.com/ubuntu/tutorial-deployment/consts"
 	"github.com/ubuntu/tutorial-deployment/internaltools"
 	"github.com/ubuntu/tutorial-deployment/paths"
+	"github.com/ubuntu/tutorial-deployment/websocket"
 )
 
-var codelabs []codelab.Codelab
+var (
+	// jsonMessages sends versioned json messages to browsers instead of plain urls of codelabs to reload,
+	// which the website expects
+	jsonMessages bool
+
+	// reloadToken is the shared token required to connect to reload messages, if any
+	reloadToken string
+)
 
 const defaultPort = 8080
 
 func main() {
 	port := flag.Int("port", defaultPort, "Port message to listen on")
+	watchMode := flag.String("watch-mode", notifyWatchMode, fmt.Sprintf("How to detect file changes: %q for file system notifications or %q to scan files periodically", notifyWatchMode, pollWatchMode))
+	pollInterval := flag.Duration("poll-interval", time.Second, fmt.Sprintf("Time between two file scans in %q watch mode", pollWatchMode))
+	gdocPollInterval := flag.Duration("gdoc-poll-interval", 10*time.Second, "Time between two checks of google docs modification time (0 to disable)")
+	remotePollInterval := flag.Duration("remote-poll-interval", 30*time.Second, "Time between two checks of remote imports and images (0 to disable)")
+	flag.BoolVar(&jsonMessages, "json-reload-messages", false, "Send versioned json messages to browsers instead of plain codelab urls to reload, as expected by the website")
+	bufferSize := flag.Int("client-buffer-size", websocket.DefaultBufferSize, "Number of messages queued for a browser before disconnecting it")
+	allowedOrigins := flag.String("allowed-origins", "", "Comma-separated origins (like https://example.com) or hosts, besides the served one, allowed to connect to reload messages. \"*\" allows any")
+	flag.StringVar(&reloadToken, "token", "", "Shared token required to connect to reload messages and send control messages")
+	presenterCode := flag.String("presenter-code", "", "Secret code required to present in presenter mode rooms (presenting is disabled without it)")
 	flag.Usage = usage
 	flag.Parse()
 	args := internaltools.UniqueStrings(flag.Args())
 
+	if *bufferSize < 1 {
+		log.Fatalf("Invalid client buffer size: %d", *bufferSize)
+	}
+	hub.SetBufferSize(*bufferSize)
+	if *allowedOrigins != "" {
+		var origins []string
+		for _, o := range strings.Split(*allowedOrigins, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				origins = append(origins, o)
+			}
+		}
+		hub.SetAllowedOrigins(origins)
+	}
+	hub.SetToken(reloadToken)
+	hub.SetPresenterCode(*presenterCode)
+
 	p := paths.New()
 	if err := p.DetectPaths(); err != nil {
 		log.Fatalf("Couldn't detect required paths: %s", err)
@@ -45,19 +79,14 @@ func main() {
 		}
 	}()
 
-	var err error
-	if watcher, err = fsnotify.NewWatcher(); err != nil {
-		log.Fatal(err)
+	watcher, err := newFileWatcher(*watchMode, *pollInterval)
+	if err != nil {
+		log.Fatalf("Couldn't create file watcher: %v", err)
 	}
 	defer watcher.Close()
 
-	template := path.Join(p.MetaData, consts.TemplateFileName)
+	ws := newWorkspace(path.Join(p.MetaData, consts.TemplateFileName), watcher)
 
-	type result struct {
-		c   codelab.Codelab
-		err error
-	}
-	ch := make(chan result)
 	// export codelabs
 	codelabRefs, err := codelab.Discover()
 	if err != nil {
@@ -66,45 +95,36 @@ func main() {
 	if err := os.RemoveAll(p.Export); err != nil {
 		log.Fatalf("Couldn't remove codelab export path %s: %v", p.Export, err)
 	}
-	for _, src := range codelabRefs {
-		go func(ref string) {
-			c, err := codelab.New(ref, p.Export, template, true)
-			if err != nil {
-				c = &codelab.Codelab{RefURI: ref}
-			}
-			ch <- result{*c, err}
-		}(src)
-	}
-
-	hasError := false
-	for _ = range codelabRefs {
-		res := <-ch
-		if res.err != nil {
-			log.Printf("ERROR in %s: %v", res.c.RefURI, res.err)
-			hasError = true
-			continue
-		}
-		codelabs = append(codelabs, res.c)
-	}
-	if hasError {
-		os.Exit(1)
+	served, failing, failed := ws.buildCodelabs(codelabRefs, p.Export)
+	if len(failed) > 0 {
+		log.Printf("%d codelab(s) failed to build: serving the other ones. Failing codelabs will be built again "+
+			"once fixed", len(failed))
 	}
+	ws.update(served, failing)
 
-	if err := refreshAPIs(codelabs, p.API); err != nil {
-		log.Fatalf("Couldn't refresh: %s", err)
+	// a failing API is generated again on next metadata or codelab change
+	if err := refreshAPIs(served, p.API); err != nil {
+		ws.setAPIError(p.MetaData, err)
+		log.Printf("ERROR: %v", err)
 	}
 
 	// Install listeners and trigger refreshes
-	if err := registerAllWatchers(); err != nil {
+	if err := ws.updateWatchers(); err != nil {
 		log.Fatalf("Couldn't register watchers: %v", err)
 	}
 	wg := sync.WaitGroup{}
 	stop := make(chan struct{})
-	listenForChanges(&wg, stop)
+	s := ws.listenForChanges(&wg, stop)
+	if *gdocPollInterval > 0 {
+		pollGdocs(&wg, stop, ws, s, *gdocPollInterval)
+	}
+	if *remotePollInterval > 0 {
+		pollRemoteFiles(&wg, stop, ws, s, *remotePollInterval)
+	}
 
-	startHTTPServer(*port, &wg, stop)
+	startHTTPServer(*port, &wg, stop, ws, s)
 
-	userstop := make(chan os.Signal)
+	userstop := make(chan os.Signal, 1)
 	signal.Notify(userstop, os.Interrupt)
 	<-userstop
 
@@ -112,6 +132,38 @@ func main() {
 	wg.Wait()
 }
 
+// buildCodelabs generates all codelabs from their references in parallel.
+// Any error is logged and recorded to be reported to browsers. Failing codelabs are returned apart, with
+// what could be built of them, along with their names.
+func (ws *workspace) buildCodelabs(refs []string, dest string) (cs []codelab.Codelab, failing []codelab.Codelab, failed []string) {
+	type result struct {
+		c   codelab.Codelab
+		err error
+	}
+	ch := make(chan result)
+	for _, src := range refs {
+		go func(ref string) {
+			start := time.Now()
+			c, err := codelab.New(ref, dest, ws.template, true)
+			ws.recordBuild(ref, start)
+			ch <- result{*c, err}
+		}(src)
+	}
+
+	for _ = range refs {
+		res := <-ch
+		if res.err != nil {
+			log.Printf("ERROR in %s: %v", res.c.RefURI, res.err)
+			failed = append(failed, ws.setBuildError(res.c.RefURI, &res.c, res.err))
+			failing = append(failing, res.c)
+			continue
+		}
+		ws.clearBuildError(res.c.RefURI)
+		cs = append(cs, res.c)
+	}
+	return cs, failing, failed
+}
+
 func refreshAPIs(codelabs []codelab.Codelab, apiDir string) error {
 	if err := os.RemoveAll(apiDir); err != nil {
 		return fmt.Errorf("Couldn't remove API export path %s: %v", apiDir, err)
@@ -135,6 +187,62 @@ destructive way. Any save on local source files (codelab markdown file or any
 referenced local images) will retrigger the corresponding codelab build and
 API generation, served by this local http webserver (default port is %d)
 
+Those tutorial paths are watched too: new, removed or renamed codelabs are
+added or removed from the served codelabs. Changes to the template rebuild every
+codelab while other metadata changes (events, categories…) regenerate the API.
+Rebuilt codelabs are swapped in once built: their last successful build is still
+served, with any build error shown on top, until the next successful one.
+Codelabs failing to build don't prevent others from being served: they are
+still watched, show an error page instead of their content and come back on the
+next successful save.
+Browsers connected to /reload receive the plain url of each codelab to reload, as
+expected by the website. With -json-reload-messages, they receive versioned json
+messages instead (reload, api-updated, asset-updated, build-started, build-failed,
+build-succeeded), for tools understanding them. They can send
+{"type": "subscribe", "codelabs": [IDs…]} to only receive messages about those
+codelabs instead of "all" of them. The same messages are available as server-sent
+events on /reload/events (?codelabs=IDs… to subscribe) where websockets are blocked,
+like behind some proxies. Redundant reloads are coalesced for browsers lagging behind,
+which are disconnected past -client-buffer-size pending messages. Hub counters are
+available under "hub" in /_status.json. Build errors are shown on top of served
+pages when they are loaded, and as soon as builds fail or succeed with json
+messages. Error pages of failing codelabs are reloaded once they build again.
+
+Only pages from the served host can connect to reload messages. When serving
+behind a reverse proxy or under another hostname, list the public origins with
+-allowed-origins. To keep other machines on the network from connecting, set a
+-token: open any served page once with ?token=<token> for the browser to remember
+it. Other clients can pass it as a token parameter or a bearer Authorization header.
+
+The state of every codelab (source, watched files, last build and error) is
+listed on /_status, with a button to rebuild it, and available in json on
+/_status.json.
+
+Google docs can't be watched like local files: their modification time is
+checked every -gdoc-poll-interval instead, less often while Google Drive is rate
+limiting us. Remote files (imports and images) are requested again every
+-remote-poll-interval, only downloaded if their server tells they changed.
+
+Editors and scripts can also POST to control endpoints to fetch remote files
+again, or rebuild anything:
+/_control/rebuild?codelab=<ID or reference> rebuilds given codelabs,
+/_control/rebuild-all rebuilds every codelab, /_control/rediscover looks for new
+or removed codelabs and /_control/refetch rebuilds every codelab using google docs
+or remote files. They require the -token, if any, and refuse requests from other
+websites, like reload messages. Without any -token, they are only available from
+this machine.
+
+Presenter mode keeps attendees on the instructor's step: start serving with a
+secret -presenter-code, then open any served page with ?room=<name>&code=<shared
+code>&presenter-code=<secret code>&role=presenter on the instructor's browser,
+which creates the room, and with the same room and code and role=follower on
+attendees' ones. Only browsers knowing the presenter code can create rooms and
+navigate.
+
+File changes are detected through file system notifications. On file systems not
+supporting them (like NFS or some container volumes), use -watch-mode=poll to
+scan watched directories every -poll-interval instead.
+
 If the currently written codelabs are out of tree, they can be specified (files or
 directories) directly on the command line.
 
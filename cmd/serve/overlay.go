@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu/tutorial-deployment/consts"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+// buildErrorsURL serves current build errors in json, for pages loaded after the failure
+const buildErrorsURL = "/_build-errors"
+
+// failingCodelabAttr is set on the body of error pages to the ID of the failing codelab
+const failingCodelabAttr = "data-failing-codelab"
+
+// failingURLAttr is set on the body of error pages to the url of the failing codelab variant
+const failingURLAttr = "data-failing-url"
+
+// reloadEventsURL streams the reload messages as server-sent events, when websockets can't get through
+const reloadEventsURL = "/reload/events"
+
+// overlayScript shows build errors received on the reload websocket on top of the page.
+// The overlay is removed once all builds succeed again, and error pages are reloaded once their codelab
+// is. Missed messages are resumed after a disconnection and the page is reloaded if too many of them were
+// missed. If the websocket never opens, messages are received as server-sent events instead.
+// Plain reload messages, sent by default, don't carry build results: errors are then only shown when the
+// page is loaded.
+const overlayScript = `<script>
+(function() {
+  var overlay = null;
+  function render(errors) {
+    if (!errors || errors.length === 0) {
+      if (overlay) {
+        overlay.parentNode.removeChild(overlay);
+        overlay = null;
+      }
+      return;
+    }
+    if (!overlay) {
+      overlay = document.createElement('div');
+      overlay.id = 'serve-build-errors';
+      overlay.style.cssText = 'position:fixed;top:0;left:0;right:0;max-height:60%;overflow:auto;z-index:2147483647;' +
+        'padding:16px;background:rgba(40,0,0,0.92);color:#fff;font:14px monospace;';
+      document.body.appendChild(overlay);
+    }
+    overlay.innerHTML = '';
+    var title = document.createElement('h2');
+    title.textContent = 'Build failed';
+    overlay.appendChild(title);
+    errors.forEach(function(e) {
+      var header = document.createElement('div');
+      header.style.cssText = 'font-weight:bold;color:#f99;margin-top:12px;';
+      header.textContent = (e.codelab ? e.codelab + ': ' : '') + e.stage + ' failed on ' + e.file;
+      var message = document.createElement('pre');
+      message.style.cssText = 'white-space:pre-wrap;margin:4px 0;';
+      message.textContent = e.message;
+      overlay.appendChild(header);
+      overlay.appendChild(message);
+    });
+  }
+  // codelab and language variant an error page is shown for, if any
+  var failing = document.body.getAttribute('` + failingCodelabAttr + `');
+  var failingURL = document.body.getAttribute('` + failingURLAttr + `');
+  // rebuilt tells if the failing codelab variant was rebuilt, older servers only sending codelab IDs
+  function rebuilt(msg) {
+    if (msg.urls && failingURL !== null) {
+      return msg.urls.indexOf(failingURL) >= 0;
+    }
+    return (msg.codelabs || []).indexOf(failing) >= 0;
+  }
+  // shared token remembered by the server, if any
+  var token = (document.cookie.match(new RegExp('(?:^|; )` + websocket.TokenCookie + `=([^;]*)')) || [])[1];
+  // last message seen, to get missed ones when reconnecting
+  var instance = '';
+  var seq = 0;
+  function handle(data) {
+    var msg = null;
+    try {
+      msg = JSON.parse(data);
+    } catch (e) {}
+    if (!msg || typeof msg !== 'object') {
+      // plain codelab urls to reload are handled by the website, except on our error pages
+      if (failingURL !== null && data === failingURL) {
+        location.reload();
+      }
+      return;
+    }
+    if (msg.instance) {
+      instance = msg.instance;
+      seq = msg.seq || 0;
+    }
+    if (msg.type === 'full-reload') {
+      location.reload();
+    } else if (msg.type === 'reload' && failing && rebuilt(msg)) {
+      location.reload();
+    } else if (msg.type === 'build-failed' || msg.type === 'build-succeeded') {
+      render(msg.errors);
+    }
+  }
+  // switch to server-sent events after this many websocket connections failed without ever opening
+  var maxFailures = 2;
+  var failures = 0;
+  var opened = false;
+  function connect() {
+    var ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/reload');
+    ws.onopen = function() {
+      opened = true;
+      ws.send(JSON.stringify({type: 'resume', instance: instance, seq: seq, token: token}));
+    };
+    ws.onmessage = function(e) { handle(e.data); };
+    ws.onclose = function() {
+      if (!opened && ++failures >= maxFailures && window.EventSource) {
+        listen();
+        return;
+      }
+      setTimeout(connect, 1000);
+    };
+  }
+  // the browser reconnects by itself, resuming from the last event id
+  function listen() {
+    var url = '` + reloadEventsURL + `';
+    if (instance) {
+      url += '?lastEventId=' + encodeURIComponent(instance + ':' + seq);
+    }
+    var events = new EventSource(url);
+    events.onmessage = function(e) { handle(e.data); };
+  }
+  var xhr = new XMLHttpRequest();
+  xhr.onload = function() { handle(xhr.responseText); };
+  xhr.open('GET', '` + buildErrorsURL + `');
+  xhr.send();
+  connect();
+})();
+</script>
+`
+
+// injectedScripts are added to website html pages: build error overlay and presenter mode
+const injectedScripts = overlayScript + presenterScript
+
+// injectScripts adds our scripts to an html page, at the end of its body.
+func injectScripts(page []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, []byte(injectedScripts)...)
+	}
+	var b bytes.Buffer
+	b.Write(page[:i])
+	b.WriteString(injectedScripts)
+	b.Write(page[i:])
+	return b.Bytes()
+}
+
+// serveHTMLWithScripts serves an html file with our scripts injected.
+func serveHTMLWithScripts(w http.ResponseWriter, r *http.Request, f string) {
+	page, err := ioutil.ReadFile(f)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(injectScripts(page))
+}
+
+// websiteHandler serves website files, injecting our scripts in html pages.
+func websiteHandler(root string) http.Handler {
+	fs := http.FileServer(http.Dir(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upath := path.Clean("/" + r.URL.Path)
+		f := filepath.Join(root, filepath.FromSlash(upath))
+		if fi, err := os.Stat(f); err == nil && fi.IsDir() {
+			// directory index, the file server takes care of redirecting urls without trailing slash
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				fs.ServeHTTP(w, r)
+				return
+			}
+			f = filepath.Join(f, "index.html")
+		}
+		// let the file server handle missing files, directory listing and redirect
+		// …/index.html to its canonical url
+		if _, err := os.Stat(f); err != nil || path.Ext(f) != ".html" || strings.HasSuffix(r.URL.Path, "/index.html") {
+			fs.ServeHTTP(w, r)
+			return
+		}
+		serveHTMLWithScripts(w, r, f)
+	})
+}
+
+// codelabSrcHandler serves generated codelabs from root. Missing pages of failing codelabs are replaced
+// by an error page, reloaded once they build again.
+func (ws *workspace) codelabSrcHandler(root string) http.Handler {
+	fs := http.StripPrefix(consts.CodelabSrcURL, http.FileServer(http.Dir(root)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upath := strings.TrimPrefix(path.Clean("/"+r.URL.Path), path.Clean(consts.CodelabSrcURL))
+		f := filepath.Join(root, filepath.FromSlash(upath))
+		// directories, even missing ones, are served by their index
+		if fi, err := os.Stat(f); (err == nil && fi.IsDir()) || (err != nil && path.Ext(f) == "") {
+			f = filepath.Join(f, "index.html")
+		}
+		if _, err := os.Stat(f); err == nil || path.Ext(f) != ".html" {
+			fs.ServeHTTP(w, r)
+			return
+		}
+		id := strings.SplitN(strings.TrimPrefix(upath, "/"), "/", 2)[0]
+		var errs []websocket.BuildError
+		for _, e := range ws.buildErrors() {
+			if e.Codelab == id {
+				errs = append(errs, e)
+			}
+		}
+		if id == "" || len(errs) == 0 {
+			fs.ServeHTTP(w, r)
+			return
+		}
+		// codelab urls are their directory, including the language of variants
+		url := strings.Trim(strings.TrimSuffix(upath, "index.html"), "/")
+		serveErrorPage(w, id, url, errs)
+	})
+}
+
+// serveErrorPage shows why a codelab variant, served at url, failed to build
+func serveErrorPage(w http.ResponseWriter, id, url string, errs []websocket.BuildError) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	errorPage.Execute(w, struct {
+		ID     string
+		URL    string
+		Errors []websocket.BuildError
+		Script template.HTML
+	}{id, url, errs, template.HTML(overlayScript)})
+}
+
+// errorPage replaces pages of a failing codelab, with its build errors
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.ID}} failed to build</title>
+</head>
+<body ` + failingCodelabAttr + `="{{.ID}}" ` + failingURLAttr + `="{{.URL}}">
+<h1>{{.ID}} failed to build</h1>
+<p>This page will be reloaded once the codelab is fixed.</p>
+{{range .Errors}}<h2>{{.Stage}} failed on {{.File}}</h2>
+<pre>{{.Message}}</pre>
+{{end}}{{.Script}}</body>
+</html>
+`))
+
+// serveBuildErrors returns the current build status, with all build errors
+func (ws *workspace) serveBuildErrors(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(ws.buildStatusMessage(len(ws.buildErrors()) > 0))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
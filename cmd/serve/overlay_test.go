@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/testtools"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+func TestInjectScripts(t *testing.T) {
+	testCases := []struct {
+		page string
+
+		want string
+	}{
+		{"<html><body><p>content</p></body></html>", "<html><body><p>content</p>" + injectedScripts + "</body></html>"},
+		{"<HTML><BODY>content</BODY></HTML>", "<HTML><BODY>content" + injectedScripts + "</BODY></HTML>"},
+		{"<p>no body</p>", "<p>no body</p>" + injectedScripts},
+		{"<body><!-- </body> --></body>", "<body><!-- </body> -->" + injectedScripts + "</body>"},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("inject in %s", tc.page), func(t *testing.T) {
+			if got := string(injectScripts([]byte(tc.page))); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWebsiteHandler(t *testing.T) {
+	root, teardown := testtools.TempDir(t)
+	defer teardown()
+	for f, content := range map[string]string{
+		"index.html":       "<body>index</body>",
+		"page.html":        "<body>page</body>",
+		"style.css":        "body {}",
+		"sub/index.html":   "<body>sub index</body>",
+		"nohtml/image.png": "image",
+	} {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		writeFile(t, p, content)
+	}
+	ts := httptest.NewServer(websiteHandler(root))
+	defer ts.Close()
+
+	testCases := []struct {
+		url string
+
+		wantStatus  int
+		wantContent string
+		wantScripts bool
+	}{
+		{"/", http.StatusOK, "index", true},
+		{"/index.html", http.StatusOK, "index", true}, // redirected to /
+		{"/page.html", http.StatusOK, "page", true},
+		{"/style.css", http.StatusOK, "body {}", false},
+		{"/sub/", http.StatusOK, "sub index", true},
+		{"/sub", http.StatusOK, "sub index", true}, // redirected to /sub/
+		{"/nohtml/", http.StatusOK, "image.png", false},
+		{"/doesnt-exist.html", http.StatusNotFound, "", false},
+		{"/../index.html", http.StatusOK, "index", true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("serve %s", tc.url), func(t *testing.T) {
+			res, err := http.Get(ts.URL + tc.url)
+			if err != nil {
+				t.Fatalf("Couldn't get %s: %v", tc.url, err)
+			}
+			defer res.Body.Close()
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("Couldn't read %s: %v", tc.url, err)
+			}
+			content := string(b)
+
+			if res.StatusCode != tc.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tc.wantStatus)
+			}
+			if !strings.Contains(content, tc.wantContent) {
+				t.Errorf("got %q; want to contain %q", content, tc.wantContent)
+			}
+			if got := strings.Contains(content, injectedScripts); got != tc.wantScripts {
+				t.Errorf("scripts injected: %v; want %v", got, tc.wantScripts)
+			}
+		})
+	}
+}
+
+func TestCodelabSrcHandler(t *testing.T) {
+	ws := newWorkspace("", nil)
+	root, teardown := testtools.TempDir(t)
+	defer teardown()
+	for _, f := range []string{"ok/index.html", "failing/img/foo.png"} {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		writeFile(t, p, "content of "+f)
+	}
+	c := codelab.Codelab{RefURI: "failing.md"}
+	c.ID = "failing"
+	ws.setBuildError(c.RefURI, &c, errors.New("<broken>"))
+	ws.setBuildError("other.md", nil, errors.New("other error"))
+
+	ts := httptest.NewServer(ws.codelabSrcHandler(root))
+	defer ts.Close()
+
+	testCases := []struct {
+		url string
+
+		wantStatus  int
+		wantContent string
+		wantScripts bool
+	}{
+		{"/src/codelabs/ok/index.html", http.StatusOK, "content of ok/index.html", false},
+		{"/src/codelabs/failing/index.html", http.StatusInternalServerError, "&lt;broken&gt;", true},
+		{"/src/codelabs/failing/", http.StatusInternalServerError, failingCodelabAttr + `="failing" ` + failingURLAttr + `="failing"`, true},
+		{"/src/codelabs/failing/fr/", http.StatusInternalServerError, failingURLAttr + `="failing/fr"`, true},
+		{"/src/codelabs/failing/fr/index.html", http.StatusInternalServerError, failingURLAttr + `="failing/fr"`, true},
+		{"/src/codelabs/failing/img/foo.png", http.StatusOK, "content of failing/img/foo.png", false},
+		{"/src/codelabs/failing/codelab.json", http.StatusNotFound, "", false},
+		{"/src/codelabs/other/index.html", http.StatusNotFound, "", false},
+		{"/src/codelabs/", http.StatusOK, "ok/", false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("serve %s", tc.url), func(t *testing.T) {
+			res, err := http.Get(ts.URL + tc.url)
+			if err != nil {
+				t.Fatalf("Couldn't get %s: %v", tc.url, err)
+			}
+			defer res.Body.Close()
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("Couldn't read %s: %v", tc.url, err)
+			}
+			content := string(b)
+
+			if res.StatusCode != tc.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tc.wantStatus)
+			}
+			if !strings.Contains(content, tc.wantContent) {
+				t.Errorf("got %q; want to contain %q", content, tc.wantContent)
+			}
+			if got := strings.Contains(content, overlayScript); got != tc.wantScripts {
+				t.Errorf("overlay script included: %v; want %v", got, tc.wantScripts)
+			}
+		})
+	}
+}
+
+func TestServeBuildErrors(t *testing.T) {
+	defer func(enabled bool) { jsonMessages = enabled }(jsonMessages)
+	ws := newWorkspace("", nil)
+	ws.setBuildError("a.md", nil, errors.New("a failed"))
+
+	// errors are shown on page load, even for plain reload messages which don't carry them
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("build errors, json messages: %v", enabled), func(t *testing.T) {
+			jsonMessages = enabled
+			w := httptest.NewRecorder()
+			ws.serveBuildErrors(w, httptest.NewRequest("GET", buildErrorsURL, nil))
+
+			var m websocket.Message
+			if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+				t.Fatalf("Couldn't decode %s: %v", w.Body.String(), err)
+			}
+			if m.Type != websocket.BuildFailedMessage || len(m.Errors) != 1 || m.Errors[0].File != "a.md" {
+				t.Errorf("got %+v; want a build failure of a.md", m)
+			}
+		})
+	}
+}
+
+func TestRememberToken(t *testing.T) {
+	defer func(token string) {
+		reloadToken = token
+		hub.SetToken(token)
+	}(reloadToken)
+
+	testCases := []struct {
+		token string
+		query string
+
+		wantCookie bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "other", false},
+		{"secret", "", false},
+		{"", "secret", false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("token %q given %q", tc.token, tc.query), func(t *testing.T) {
+			reloadToken = tc.token
+			hub.SetToken(tc.token)
+			served := false
+			h := rememberToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true }))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/?token="+tc.query, nil))
+
+			if !served {
+				t.Error("Request wasn't served")
+			}
+			cookie := rec.Header().Get("Set-Cookie")
+			if tc.wantCookie && !strings.HasPrefix(cookie, websocket.TokenCookie+"="+tc.query) {
+				t.Errorf("got cookie %q; want the token to be remembered", cookie)
+			}
+			if tc.wantCookie && !strings.Contains(cookie, "SameSite=Strict") {
+				t.Errorf("got cookie %q; want it restricted to this site", cookie)
+			}
+			if !tc.wantCookie && cookie != "" {
+				t.Errorf("got cookie %q; want none", cookie)
+			}
+		})
+	}
+}
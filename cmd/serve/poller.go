@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/claattools"
+	"github.com/ubuntu/tutorial-deployment/internaltools"
+)
+
+// maxPollBackoff is the maximum factor applied to the poll interval while rate limited
+const maxPollBackoff = 16
+
+// poller checks remote files, which can't be watched, for changes
+type poller interface {
+	// poll checks every file once, returning true if it was rate limited
+	poll() (limited bool)
+}
+
+// filePoller is what pollers of google docs and remote files share: they schedule rebuilds of codelabs
+// using changed files and only log the same error once per file.
+type filePoller struct {
+	schedule func(targets ...string)
+	failures map[string]string // last error per file, only logged once
+}
+
+func newFilePoller(schedule func(targets ...string)) filePoller {
+	return filePoller{
+		schedule: schedule,
+		failures: make(map[string]string),
+	}
+}
+
+// pollFiles checks every file once, in order, with check telling if it changed. Rebuilds of the codelabs
+// using changed files, given with each file, are scheduled.
+// It stops at the first request refused for rate limit, returning true.
+func (p *filePoller) pollFiles(files map[string][]string, check func(f string) (changed bool, err error)) (limited bool) {
+	var names []string
+	for f := range files {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	var changed []string
+	defer func() {
+		if len(changed) > 0 {
+			p.schedule(internaltools.UniqueStrings(changed)...)
+		}
+	}()
+	for _, f := range names {
+		modified, err := check(f)
+		if _, ok := err.(*claattools.RateLimitError); ok {
+			return true
+		}
+		if err != nil {
+			if p.failures[f] != err.Error() {
+				log.Printf("Couldn't poll %s for changes: %v", f, err)
+				p.failures[f] = err.Error()
+			}
+			continue
+		}
+		delete(p.failures, f)
+
+		if modified {
+			log.Printf("%s was modified", f)
+			changed = append(changed, files[f]...)
+		}
+	}
+
+	// forget files which aren't used anymore
+	for f := range p.failures {
+		if _, ok := files[f]; !ok {
+			delete(p.failures, f)
+		}
+	}
+	return false
+}
+
+// runPoller starts polling p every interval until stop is closed. It backs off while rate limited.
+func runPoller(wg *sync.WaitGroup, stop <-chan struct{}, p poller, interval time.Duration, name string) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait := interval
+		for {
+			select {
+			case <-time.After(wait):
+			case <-stop:
+				return
+			}
+			limited := p.poll()
+			wait = nextWait(interval, wait, limited)
+			if limited {
+				log.Printf("Rate limit exceeded: polling %s again in %s", name, wait)
+			}
+		}
+	}()
+}
+
+// nextWait returns the time to wait before next poll: the poll interval, doubled from current wait
+// while rate limited
+func nextWait(interval, wait time.Duration, limited bool) time.Duration {
+	if !limited {
+		return interval
+	}
+	wait *= 2
+	if max := interval * maxPollBackoff; wait > max {
+		wait = max
+	}
+	return wait
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/claattools"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestPollBackoff(t *testing.T) {
+	testCases := []struct {
+		wait    time.Duration
+		limited bool
+
+		want time.Duration
+	}{
+		{time.Second, false, time.Second},
+		{8 * time.Second, false, time.Second},
+		{time.Second, true, 2 * time.Second},
+		{4 * time.Second, true, 8 * time.Second},
+		{10 * time.Second, true, maxPollBackoff * time.Second},
+		{maxPollBackoff * time.Second, true, maxPollBackoff * time.Second},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("wait after %s, rate limited: %v", tc.wait, tc.limited), func(t *testing.T) {
+			if got := nextWait(time.Second, tc.wait, tc.limited); got != tc.want {
+				t.Errorf("got %s; want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPollerStalledServer(t *testing.T) {
+	defer func(timeout time.Duration) { claattools.PollTimeout = timeout }(claattools.PollTimeout)
+	claattools.PollTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer ts.Close()
+	defer close(release)
+
+	ws := newWorkspace("", nil)
+	url := ts.URL + "/a.png"
+	ws.update([]codelab.Codelab{{RefURI: "a.md", RemoteFiles: []string{url}}}, nil)
+	p := newRemotePoller(ws, func(targets ...string) { t.Errorf("Unexpected rebuild of %v", targets) })
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	runPoller(&wg, stop, p, 10*time.Millisecond, "remote files")
+	// let it poll the stalled server
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Poller didn't stop while polling a stalled server")
+	}
+	if _, ok := p.failures[url]; !ok {
+		t.Errorf("got failures %v; want %s to have timed out", p.failures, url)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// mtimeGranularity is the coarsest modification time resolution we expect (FAT, some network file systems).
+// Files modified more recently than this are hashed on each poll as a new write may not change their mtime.
+const mtimeGranularity = 2 * time.Second
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// pollWatcher detects changes by scanning watched directories periodically, for file systems
+// not supporting notifications (NFS, 9p, some docker bind mounts…).
+// Files are compared by modification time, size, permissions and content hash.
+type pollWatcher struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileState // watched directories content
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// fileState is the state of a directory entry at a given poll
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	hash    uint64
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		dirs:     make(map[string]map[string]fileState),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Add starts watching dir content. Adding an already watched directory is a no-op.
+func (w *pollWatcher) Add(dir string) error {
+	w.mu.Lock()
+	_, ok := w.dirs[dir]
+	w.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	content, err := scanDir(dir, nil)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[dir] = content
+	w.mu.Unlock()
+	return nil
+}
+
+// Remove stops watching dir
+func (w *pollWatcher) Remove(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.dirs[dir]; !ok {
+		return fmt.Errorf("can't remove non-existent watch for: %s", dir)
+	}
+	delete(w.dirs, dir)
+	return nil
+}
+
+func (w *pollWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *pollWatcher) Errors() <-chan error          { return w.errors }
+
+// Close stops polling
+func (w *pollWatcher) Close() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+	return nil
+}
+
+func (w *pollWatcher) run() {
+	defer close(w.done)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.poll()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// poll scans all watched directories and sends events for any difference with previous scan
+func (w *pollWatcher) poll() {
+	w.mu.Lock()
+	dirs := make(map[string]map[string]fileState)
+	for dir, content := range w.dirs {
+		dirs[dir] = content
+	}
+	w.mu.Unlock()
+
+	for dir, old := range dirs {
+		content, err := scanDir(dir, old)
+		if os.IsNotExist(err) {
+			// removed directories are unwatched, as with notifications
+			w.mu.Lock()
+			delete(w.dirs, dir)
+			w.mu.Unlock()
+			if !w.send(fsnotify.Event{Name: dir, Op: fsnotify.Remove}) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			select {
+			case w.errors <- err:
+			case <-w.stop:
+				return
+			}
+			continue
+		}
+
+		w.mu.Lock()
+		// only update if not unwatched in between
+		if _, ok := w.dirs[dir]; ok {
+			w.dirs[dir] = content
+		}
+		w.mu.Unlock()
+
+		for _, e := range diffDir(dir, old, content) {
+			if !w.send(e) {
+				return
+			}
+		}
+	}
+}
+
+// send an event, returning false if the watcher was closed
+func (w *pollWatcher) send(e fsnotify.Event) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// scanDir returns the state of every entry in dir. Hashes from previous scan are reused
+// if the file didn't change.
+func scanDir(dir string, previous map[string]fileState) (map[string]fileState, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	content := make(map[string]fileState)
+	for _, fi := range fis {
+		s := fileState{modTime: fi.ModTime(), size: fi.Size(), mode: fi.Mode()}
+		if fi.Mode().IsRegular() {
+			old, ok := previous[fi.Name()]
+			if ok && old.modTime.Equal(s.modTime) && old.size == s.size && time.Since(s.modTime) > mtimeGranularity {
+				s.hash = old.hash
+			} else {
+				b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+				if err != nil {
+					// the file can be removed in between, it will be detected on next poll
+					continue
+				}
+				s.hash = crc64.Checksum(b, crcTable)
+			}
+		}
+		content[fi.Name()] = s
+	}
+	return content, nil
+}
+
+// diffDir returns file events between two directory scans
+func diffDir(dir string, old, new map[string]fileState) []fsnotify.Event {
+	var events []fsnotify.Event
+	for name, s := range new {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create})
+		case s.mode.IsDir() && o.mode.IsDir():
+			// content changes are reported by watching the directory itself
+		case s.hash != o.hash || s.size != o.size || s.mode.IsDir() != o.mode.IsDir():
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Write})
+		case s.mode != o.mode:
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Chmod})
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+		}
+	}
+	return events
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ubuntu/tutorial-deployment/testtools"
+)
+
+func TestPollWatcherEvents(t *testing.T) {
+	testCases := []struct {
+		name   string
+		change func(t *testing.T, dir string)
+		want   fsnotify.Event
+	}{
+		{"create", func(t *testing.T, dir string) {
+			writeFile(t, filepath.Join(dir, "new"), "content")
+		}, fsnotify.Event{Name: "new", Op: fsnotify.Create}},
+		{"write", func(t *testing.T, dir string) {
+			writeFile(t, filepath.Join(dir, "existing"), "other content")
+		}, fsnotify.Event{Name: "existing", Op: fsnotify.Write}},
+		{"write with same size and modification time", func(t *testing.T, dir string) {
+			f := filepath.Join(dir, "existing")
+			fi, err := os.Stat(f)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			writeFile(t, f, "CONTENT")
+			if err := os.Chtimes(f, fi.ModTime(), fi.ModTime()); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}, fsnotify.Event{Name: "existing", Op: fsnotify.Write}},
+		{"chmod", func(t *testing.T, dir string) {
+			if err := os.Chmod(filepath.Join(dir, "existing"), 0600); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}, fsnotify.Event{Name: "existing", Op: fsnotify.Chmod}},
+		{"remove", func(t *testing.T, dir string) {
+			remove(t, filepath.Join(dir, "existing"))
+		}, fsnotify.Event{Name: "existing", Op: fsnotify.Remove}},
+		{"remove watched directory", func(t *testing.T, dir string) {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}, fsnotify.Event{Name: "", Op: fsnotify.Remove}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, teardown := testtools.TempDir(t)
+			defer teardown()
+			writeFile(t, filepath.Join(dir, "existing"), "content")
+			if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			w := newPollWatcher(testPollInterval)
+			defer w.Close()
+			if err := w.Add(dir); err != nil {
+				t.Fatalf("Couldn't watch %s: %v", dir, err)
+			}
+
+			tc.change(t, dir)
+
+			want := fsnotify.Event{Name: filepath.Join(dir, tc.want.Name), Op: tc.want.Op}
+			select {
+			case e := <-w.Events():
+				if e != want {
+					t.Errorf("got event %v; want %v", e, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no event received; want %v", want)
+			}
+			noEvent(t, w)
+		})
+	}
+}
+
+func TestPollWatcherIgnoresUnchangedContent(t *testing.T) {
+	dir, teardown := testtools.TempDir(t)
+	defer teardown()
+	f := filepath.Join(dir, "existing")
+	writeFile(t, f, "content")
+	w := newPollWatcher(testPollInterval)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatalf("Couldn't watch %s: %v", dir, err)
+	}
+
+	// saving without any modification
+	writeFile(t, f, "content")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(f, later, later); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	noEvent(t, w)
+}
+
+func TestPollWatcherRemove(t *testing.T) {
+	dir, teardown := testtools.TempDir(t)
+	defer teardown()
+	w := newPollWatcher(testPollInterval)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatalf("Couldn't watch %s: %v", dir, err)
+	}
+	if err := w.Remove(dir); err != nil {
+		t.Fatalf("Couldn't unwatch %s: %v", dir, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "new"), "content")
+
+	noEvent(t, w)
+	if err := w.Remove(dir); err == nil {
+		t.Error("removing an unwatched directory should have errored")
+	}
+}
+
+func noEvent(t *testing.T, w *pollWatcher) {
+	select {
+	case e := <-w.Events():
+		t.Errorf("unexpected event: %v", e)
+	case <-time.After(20 * testPollInterval):
+	}
+}
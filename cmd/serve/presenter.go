@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/ubuntu/tutorial-deployment/consts"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+// presenterScript synchronizes codelab step navigation in presenter rooms.
+// Opening a page with ?room=<name>&code=<code>&role=<presenter|follower> joins the room for the
+// browser session: the presenter position is followed by every follower in the same room. Presenters
+// also need a &presenter-code=<code>, the -presenter-code of the server which attendees don't know.
+// Followers wait for a presenter to create the room.
+const presenterScript = `<script>
+(function() {
+  var storageKey = 'serve-presenter';
+  var root = '` + consts.ServeRootURL + `';
+  var params = new URLSearchParams(location.search);
+  var session = JSON.parse(sessionStorage.getItem(storageKey) || 'null');
+  if (params.get('room')) {
+    session = {room: params.get('room'), code: params.get('code'), presenterCode: params.get('presenter-code'),
+      role: params.get('role') || 'follower'};
+    sessionStorage.setItem(storageKey, JSON.stringify(session));
+  }
+  if (!session) {
+    return;
+  }
+
+  // shared token remembered by the server, if any
+  var token = (document.cookie.match(new RegExp('(?:^|; )` + websocket.TokenCookie + `=([^;]*)')) || [])[1];
+  var ws = null;
+  var published = null;
+  var joined = false;
+  function position() {
+    if (location.pathname.indexOf(root) !== 0) {
+      return null;
+    }
+    var codelab = location.pathname.slice(root.length).split('/')[0];
+    if (!codelab) {
+      return null;
+    }
+    return {codelab: codelab, step: parseInt(location.hash.slice(1), 10) || 0};
+  }
+  function publish() {
+    var p = position();
+    if (session.role !== 'presenter' || !p || !ws || ws.readyState !== WebSocket.OPEN) {
+      return;
+    }
+    if (published && published.codelab === p.codelab && published.step === p.step) {
+      return;
+    }
+    ws.send(JSON.stringify({type: 'navigate', position: p, token: token}));
+    published = p;
+  }
+  function follow(p) {
+    var current = position();
+    if (current && current.codelab === p.codelab) {
+      if (current.step !== p.step) {
+        location.hash = '#' + p.step;
+      }
+      return;
+    }
+    location.assign(root + p.codelab + '#' + p.step);
+  }
+  function join() {
+    if (!ws || ws.readyState !== WebSocket.OPEN) {
+      return;
+    }
+    ws.send(JSON.stringify({type: 'join', room: session.room, code: session.code, presenterCode: session.presenterCode,
+      role: session.role, token: token}));
+  }
+  function connect() {
+    ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/reload');
+    ws.onopen = function() {
+      published = null;
+      joined = false;
+      join();
+    };
+    ws.onmessage = function(e) {
+      var msg;
+      try {
+        msg = JSON.parse(e.data);
+      } catch (err) {
+        return;
+      }
+      if (msg.type === 'joined') {
+        joined = true;
+        publish();
+      } else if (msg.type === 'navigate' && session.role === 'follower') {
+        follow(msg.position);
+      } else if (msg.type === 'error') {
+        console.error('Presenter mode: ' + msg.reason);
+        // the room may not be created yet by its presenter
+        if (!joined && session.role === 'follower') {
+          setTimeout(join, 2000);
+        }
+      }
+    };
+    ws.onclose = function() { setTimeout(connect, 1000); };
+  }
+  // single page application navigation doesn't always trigger events
+  window.addEventListener('hashchange', publish);
+  setInterval(publish, 500);
+  connect();
+})();
+</script>
+`
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/claattools"
+)
+
+// remotePoller polls remote imports and images, which can't be watched, with conditional requests and
+// schedules rebuilds of codelabs using changed ones.
+type remotePoller struct {
+	filePoller
+	ws    *workspace
+	check func(url string, last claattools.RemoteVersion) (claattools.RemoteVersion, error)
+
+	versions map[string]claattools.RemoteVersion // last known version per remote file
+}
+
+func newRemotePoller(ws *workspace, schedule func(targets ...string)) *remotePoller {
+	return &remotePoller{
+		filePoller: newFilePoller(schedule),
+		ws:         ws,
+		check:      claattools.CheckRemote,
+		versions:   make(map[string]claattools.RemoteVersion),
+	}
+}
+
+// pollRemoteFiles starts polling remote files every interval until stop is closed
+func pollRemoteFiles(wg *sync.WaitGroup, stop <-chan struct{}, ws *workspace, s *rebuildScheduler, interval time.Duration) {
+	runPoller(wg, stop, newRemotePoller(ws, s.schedule), interval, "remote files")
+}
+
+// isRemoteFile returns true for http(s) urls which aren't google docs
+func isRemoteFile(f string) bool {
+	u, err := url.Parse(f)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && !claattools.IsGdoc(f)
+}
+
+// poll checks every remote file once and schedules rebuilds of codelabs using modified ones.
+// The first time a file is polled only records its version.
+// It stops at the first request refused for rate limit, returning true.
+func (p *remotePoller) poll() (limited bool) {
+	files := p.ws.remoteFiles(isRemoteFile)
+	limited = p.pollFiles(files, func(f string) (bool, error) {
+		last, known := p.versions[f]
+		v, err := p.check(f, last)
+		if err != nil {
+			return false, err
+		}
+		p.versions[f] = v
+		return known && v.Hash != last.Hash, nil
+	})
+
+	// forget files which aren't used anymore
+	for f := range p.versions {
+		if _, ok := files[f]; !ok {
+			delete(p.versions, f)
+		}
+	}
+	return limited
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ubuntu/tutorial-deployment/claattools"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestIsRemoteFile(t *testing.T) {
+	testCases := []struct {
+		f    string
+		want bool
+	}{
+		{"https://example.com/snippet.md", true},
+		{"http://example.com/image.png", true},
+		{"https://docs.google.com/document/d/doc/edit", false},
+		{"gdoc:doc", false},
+		{"local/image.png", false},
+		{"ftp://example.com/image.png", false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("is %s a remote file", tc.f), func(t *testing.T) {
+			if got := isRemoteFile(tc.f); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRemotePoll(t *testing.T) {
+	ws := newWorkspace("", nil)
+	const (
+		snippet = "https://example.com/snippet.md"
+		image   = "https://example.com/image.png"
+	)
+	ws.update([]codelab.Codelab{
+		{RefURI: "a.md", RemoteFiles: []string{snippet, image, "https://docs.google.com/document/d/doc/edit"}},
+		{RefURI: "b.md", RemoteFiles: []string{snippet}},
+		{RefURI: "gdoc:doc"},
+	}, nil)
+
+	var scheduled [][]string
+	hashes := map[string]string{snippet: "1", image: "1"}
+	returned := make(map[string]claattools.RemoteVersion)
+	var checkErr error
+	p := newRemotePoller(ws, func(targets ...string) { scheduled = append(scheduled, targets) })
+	p.check = func(f string, last claattools.RemoteVersion) (claattools.RemoteVersion, error) {
+		if checkErr != nil {
+			return last, checkErr
+		}
+		h, ok := hashes[f]
+		if !ok {
+			t.Errorf("Unexpected poll of %s", f)
+		}
+		if last != returned[f] {
+			t.Errorf("%s polled with %+v; want the last returned version %+v", f, last, returned[f])
+		}
+		returned[f] = claattools.RemoteVersion{ETag: h, Hash: h}
+		return returned[f], nil
+	}
+
+	testCases := []struct {
+		name    string
+		changed []string
+		err     error
+
+		wantLimited   bool
+		wantScheduled []string
+	}{
+		{"first poll only records versions", nil, nil, false, nil},
+		{"nothing changed", nil, nil, false, nil},
+		{"image changed", []string{image}, nil, false, []string{"a.md"}},
+		{"shared snippet changed", []string{snippet}, nil, false, []string{"a.md", "b.md"}},
+		{"rate limited", []string{image}, &claattools.RateLimitError{URL: image}, true, nil},
+		{"changed while rate limited", nil, nil, false, []string{"a.md"}},
+		{"failing file", []string{snippet}, errors.New("failing"), false, nil},
+		{"changed while failing", nil, nil, false, []string{"a.md", "b.md"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheduled = nil
+			for _, f := range tc.changed {
+				hashes[f] += "1"
+			}
+			checkErr = tc.err
+
+			if limited := p.poll(); limited != tc.wantLimited {
+				t.Errorf("got rate limited: %v; want %v", limited, tc.wantLimited)
+			}
+			var want [][]string
+			if tc.wantScheduled != nil {
+				want = [][]string{tc.wantScheduled}
+			}
+			if !reflect.DeepEqual(scheduled, want) {
+				t.Errorf("got %v scheduled; want %v", scheduled, want)
+			}
+		})
+	}
+
+	// removed files are forgotten
+	ws.update(nil, nil)
+	p.poll()
+	if len(p.versions) != 0 {
+		t.Errorf("got %v versions; want removed files to be forgotten", p.versions)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// rebuildScheduler debounces changes per target (codelab or special targets like the API), merges
+// them in a single rebuild and runs it off the event loop.
+// A running rebuild is cancelled when newer changes arrive for one of its targets.
+type rebuildScheduler struct {
+	delay   time.Duration
+	rebuild func(ctx context.Context, targets []string) error
+
+	changes chan []string
+	quit    chan struct{}
+}
+
+// newRebuildScheduler creates a scheduler calling rebuild once targets didn't change for delay.
+func newRebuildScheduler(delay time.Duration, rebuild func(ctx context.Context, targets []string) error) *rebuildScheduler {
+	return &rebuildScheduler{
+		delay:   delay,
+		rebuild: rebuild,
+		changes: make(chan []string),
+		quit:    make(chan struct{}),
+	}
+}
+
+// schedule a rebuild for those targets
+func (s *rebuildScheduler) schedule(targets ...string) {
+	select {
+	case s.changes <- targets:
+	case <-s.quit:
+	}
+}
+
+// run the scheduler loop until stop is closed. Any running rebuild is then cancelled.
+func (s *rebuildScheduler) run(stop <-chan struct{}) {
+	defer close(s.quit)
+
+	pending := make(map[string]time.Time) // targets waiting for their debounce deadline
+	ready := make(map[string]bool)        // targets to include in next rebuild
+
+	var running map[string]bool
+	var ctx context.Context
+	var cancel context.CancelFunc
+	done := make(chan error)
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case targets := <-s.changes:
+			deadline := time.Now().Add(s.delay)
+			for _, t := range targets {
+				pending[t] = deadline
+				delete(ready, t)
+				if running[t] {
+					cancel()
+				}
+			}
+
+		case <-timer:
+
+		case err := <-done:
+			if ctx.Err() != nil {
+				// cancelled: rebuild it again with newer changes, once they are debounced
+				var latest time.Time
+				for _, deadline := range pending {
+					if deadline.After(latest) {
+						latest = deadline
+					}
+				}
+				for t := range running {
+					if _, ok := pending[t]; !ok {
+						pending[t] = latest
+					}
+				}
+			} else if err != nil {
+				log.Print(err)
+			}
+			cancel()
+			running, ctx, cancel = nil, nil, nil
+
+		case <-stop:
+			if cancel != nil {
+				cancel()
+				<-done
+			}
+			return
+		}
+
+		// move targets we didn't get any change for during delay to ready ones
+		now := time.Now()
+		var next time.Time
+		for t, deadline := range pending {
+			if !deadline.After(now) {
+				ready[t] = true
+				delete(pending, t)
+				continue
+			}
+			if next.IsZero() || deadline.Before(next) {
+				next = deadline
+			}
+		}
+		timer = nil
+		if !next.IsZero() {
+			timer = time.After(next.Sub(now))
+		}
+
+		if running != nil || len(ready) == 0 {
+			continue
+		}
+		running, ready = ready, make(map[string]bool)
+		ctx, cancel = context.WithCancel(context.Background())
+		var targets []string
+		for t := range running {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
+		go func(ctx context.Context) {
+			done <- s.rebuild(ctx, targets)
+		}(ctx)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDelay = 20 * time.Millisecond
+
+func TestSchedulerDebounceBurst(t *testing.T) {
+	calls, s, stop := createScheduler(t, nil)
+	defer stop()
+
+	for i := 0; i < 5; i++ {
+		s.schedule("a")
+		<-time.After(testDelay / 4)
+	}
+
+	wantCall(t, calls, []string{"a"})
+	noCall(t, calls)
+}
+
+func TestSchedulerMergeTargets(t *testing.T) {
+	calls, s, stop := createScheduler(t, nil)
+	defer stop()
+
+	s.schedule("a")
+	s.schedule("b", "c")
+	s.schedule("a")
+
+	wantCall(t, calls, []string{"a", "b", "c"})
+	noCall(t, calls)
+}
+
+func TestSchedulerSeparatedChanges(t *testing.T) {
+	calls, s, stop := createScheduler(t, nil)
+	defer stop()
+
+	s.schedule("a")
+	wantCall(t, calls, []string{"a"})
+	s.schedule("a")
+	wantCall(t, calls, []string{"a"})
+	noCall(t, calls)
+}
+
+func TestSchedulerCancelRunningOnNewerChanges(t *testing.T) {
+	block := make(chan struct{})
+	calls, s, stop := createScheduler(t, block)
+	defer stop()
+
+	s.schedule("a", "b")
+	wantCall(t, calls, []string{"a", "b"})
+
+	// newer change on a target being rebuilt
+	s.schedule("a")
+	// the first rebuild is cancelled and everything is rebuilt again
+	wantCall(t, calls, []string{"a", "b"})
+	close(block)
+	noCall(t, calls)
+}
+
+func TestSchedulerDontCancelRunningOnOtherChanges(t *testing.T) {
+	block := make(chan struct{})
+	calls, s, stop := createScheduler(t, block)
+	defer stop()
+
+	s.schedule("a")
+	wantCall(t, calls, []string{"a"})
+
+	// change on another target is queued, after current rebuild
+	s.schedule("b")
+	noCall(t, calls)
+	close(block)
+	wantCall(t, calls, []string{"b"})
+}
+
+func TestSchedulerStopCancelsRunning(t *testing.T) {
+	block := make(chan struct{})
+	calls, s, stop := createScheduler(t, block)
+
+	s.schedule("a")
+	wantCall(t, calls, []string{"a"})
+
+	// this shouldn't block
+	stop()
+
+	// no more scheduling once stopped
+	s.schedule("b")
+}
+
+// createScheduler returns a running scheduler reporting rebuild targets on the returned channel.
+// If block isn't nil, rebuilds are blocked until either block is closed or they are cancelled.
+func createScheduler(t *testing.T, block <-chan struct{}) (<-chan []string, *rebuildScheduler, func()) {
+	calls := make(chan []string, 10)
+	s := newRebuildScheduler(testDelay, func(ctx context.Context, targets []string) error {
+		calls <- targets
+		if block == nil {
+			return nil
+		}
+		select {
+		case <-block:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.run(stop)
+	}()
+	return calls, s, func() {
+		close(stop)
+		wg.Wait()
+	}
+}
+
+func wantCall(t *testing.T, calls <-chan []string, want []string) {
+	select {
+	case got := <-calls:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("rebuild called with %+v; want %+v", got, want)
+		}
+	case <-time.After(10 * testDelay):
+		t.Fatalf("rebuild wasn't called; wanted with %+v", want)
+	}
+}
+
+func noCall(t *testing.T, calls <-chan []string) {
+	select {
+	case got := <-calls:
+		t.Errorf("rebuild unexpectedly called with %+v", got)
+	case <-time.After(5 * testDelay):
+	}
+}
@@ -16,23 +16,32 @@ import (
 
 var hub = websocket.NewHub()
 
-func startHTTPServer(port int, wg *sync.WaitGroup, stop <-chan struct{}) {
-	s := &http.Server{Addr: fmt.Sprintf(":%d", port)}
+func startHTTPServer(port int, wg *sync.WaitGroup, stop <-chan struct{}, ws *workspace, rebuilds *rebuildScheduler) {
+	s := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: rememberToken(http.DefaultServeMux)}
 	log.Printf("Serving on http://localhost:%d\n", port)
 
 	p := paths.New()
 
 	// websocket handling
 	http.HandleFunc("/reload", hub.NewClient)
+	http.HandleFunc(reloadEventsURL, hub.NewSSEClient)
+	http.HandleFunc(buildErrorsURL, ws.serveBuildErrors)
+	// build status dashboard
+	http.HandleFunc(statusURL, serveStatusPage)
+	http.HandleFunc(statusJSONURL, ws.serveStatusJSON)
+	// editors and scripts can request rebuilds
+	http.Handle(controlURL, ws.controlHandler(rebuilds))
 
 	http.Handle(consts.APIURL, http.StripPrefix(consts.APIURL, http.FileServer(http.Dir(p.API))))
 	http.Handle(consts.ImagesURL, http.StripPrefix(consts.ImagesURL, http.FileServer(http.Dir(p.Images))))
-	http.Handle(consts.CodelabSrcURL, http.StripPrefix(consts.CodelabSrcURL, http.FileServer(http.Dir(p.Export))))
+	// failing codelabs show an error page
+	http.Handle(consts.CodelabSrcURL, ws.codelabSrcHandler(p.Export))
 	// always serve root file for tutorials if page refreshed
+	// website pages are showing build errors in an overlay and can follow a presenter
 	http.HandleFunc(consts.ServeRootURL, func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, path.Join(p.Website, "index.html"))
+		serveHTMLWithScripts(w, r, path.Join(p.Website, "index.html"))
 	})
-	http.Handle("/", http.FileServer(http.Dir(p.Website)))
+	http.Handle("/", websiteHandler(p.Website))
 
 	wg.Add(3)
 	// websocket
@@ -62,3 +71,15 @@ func startHTTPServer(port int, wg *sync.WaitGroup, stop <-chan struct{}) {
 	}()
 
 }
+
+// rememberToken keeps a valid token given in the url in a cookie, sent by the browser when connecting
+// to reload messages, and read by our scripts for their control messages.
+func rememberToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); reloadToken != "" && token != "" && hub.Authorized(r) {
+			// other websites can't make the browser send it along their requests
+			http.SetCookie(w, &http.Cookie{Name: websocket.TokenCookie, Value: token, Path: "/", SameSite: http.SameSiteStrictMode})
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/consts"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+const (
+	statusURL     = "/_status"
+	statusJSONURL = "/_status.json"
+
+	markdownSource = "markdown"
+	gdocSource     = "gdoc"
+)
+
+// codelabStatus is what the status page shows about a codelab
+type codelabStatus struct {
+	Ref        string                `json:"ref"`
+	Source     string                `json:"source"` // markdown or gdoc
+	ID         string                `json:"id,omitempty"`
+	Title      string                `json:"title,omitempty"`
+	URL        string                `json:"url,omitempty"`
+	Files      []string              `json:"files"`
+	Remote     []string              `json:"remote,omitempty"` // google docs and remote files, fetched again on rebuilds
+	LastBuild  *time.Time            `json:"lastBuild,omitempty"`
+	DurationMs int64                 `json:"durationMs"`
+	Error      *websocket.BuildError `json:"error,omitempty"`
+}
+
+// serveStatus is the status of every codelab and of the API generation
+type serveStatus struct {
+	Codelabs []codelabStatus       `json:"codelabs"`
+	APIError *websocket.BuildError `json:"apiError,omitempty"`
+	Hub      *websocket.Stats      `json:"hub,omitempty"` // reload messages counters, for debugging
+}
+
+// buildTime is when a codelab was last built and how long it took
+type buildTime struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// recordBuild saves the time of a codelab build which started at start
+func (ws *workspace) recordBuild(ref string, start time.Time) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.builds[ref] = buildTime{at: start, duration: time.Since(start)}
+}
+
+// oldestBuild returns when the oldest last build of given codelabs started, if they were all built
+func (ws *workspace) oldestBuild(refs []string) (time.Time, bool) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	var oldest time.Time
+	for i, ref := range refs {
+		b, ok := ws.builds[ref]
+		if !ok {
+			return time.Time{}, false
+		}
+		if i == 0 || b.at.Before(oldest) {
+			oldest = b.at
+		}
+	}
+	return oldest, len(refs) > 0
+}
+
+// remoteFiles returns google docs and remote files of served and failing codelabs matching filter, with the
+// references of codelabs using them. Codelabs which are google docs are their own remote file.
+func (ws *workspace) remoteFiles(filter func(string) bool) map[string][]string {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	owners := make(map[string]map[string]bool)
+	add := func(f, ref string) {
+		if !filter(f) {
+			return
+		}
+		if owners[f] == nil {
+			owners[f] = make(map[string]bool)
+		}
+		owners[f][ref] = true
+	}
+	for _, c := range ws.all() {
+		if sourceType(c.RefURI) == gdocSource {
+			add(c.RefURI, c.RefURI)
+		}
+		for _, f := range c.RemoteFiles {
+			add(f, c.RefURI)
+		}
+	}
+	for ref := range ws.errors {
+		if ref != apiErrorRef && sourceType(ref) == gdocSource {
+			add(ref, ref)
+		}
+	}
+
+	files := make(map[string][]string)
+	for f, refs := range owners {
+		files[f] = sortedKeys(refs)
+	}
+	return files
+}
+
+// sourceType returns if a codelab reference is a markdown file or a google doc
+func sourceType(ref string) string {
+	if strings.HasPrefix(ref, consts.GdocPrefix) {
+		return gdocSource
+	}
+	return markdownSource
+}
+
+// status returns the status of served and failing codelabs, ordered by reference
+func (ws *workspace) status() serveStatus {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	statuses := make(map[string]codelabStatus)
+	for _, c := range ws.all() {
+		statuses[c.RefURI] = codelabStatus{
+			Ref:    c.RefURI,
+			Source: sourceType(c.RefURI),
+			ID:     c.ID,
+			Title:  c.Title,
+			URL:    c.URL,
+			Files:  append([]string{}, c.FilesWatched...),
+			Remote: append([]string(nil), c.RemoteFiles...),
+		}
+	}
+	var s serveStatus
+	for ref, e := range ws.errors {
+		e := e
+		if ref == apiErrorRef {
+			s.APIError = &e
+			continue
+		}
+		cs, ok := statuses[ref]
+		if !ok {
+			// never built
+			cs = codelabStatus{Ref: ref, Source: sourceType(ref), Files: []string{}}
+		}
+		cs.Error = &e
+		statuses[ref] = cs
+	}
+
+	var refs []string
+	for ref := range statuses {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	s.Codelabs = []codelabStatus{}
+	for _, ref := range refs {
+		cs := statuses[ref]
+		if b, ok := ws.builds[ref]; ok {
+			at := b.at
+			cs.LastBuild = &at
+			cs.DurationMs = int64(b.duration / time.Millisecond)
+		}
+		s.Codelabs = append(s.Codelabs, cs)
+	}
+	return s
+}
+
+// serveStatusJSON returns the status of every codelab, with counters of the reload messages hub
+func (ws *workspace) serveStatusJSON(w http.ResponseWriter, r *http.Request) {
+	s := ws.status()
+	stats := hub.Stats()
+	s.Hub = &stats
+	b, err := json.Marshal(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serveStatusPage returns the status page, rendering the json status and updating live
+func serveStatusPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != statusURL {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, statusPage)
+}
+
+// statusPage lists codelabs from the json status, refreshed on any build message from the reload websocket
+const statusPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Codelabs build status</title>
+<style>
+  body { font-family: sans-serif; margin: 24px; color: #111; }
+  table { border-collapse: collapse; width: 100%; }
+  th, td { text-align: left; vertical-align: top; padding: 6px 8px; border-bottom: 1px solid #ddd; }
+  tr.failed { background: #fde8e8; }
+  tr.building td.state { color: #a60; }
+  td.files, pre { font: 12px monospace; margin: 0; white-space: pre-wrap; }
+  #api { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Codelabs build status</h1>
+<p id="summary"></p>
+<pre id="api"></pre>
+<table>
+  <thead><tr><th>Codelab</th><th>Source</th><th>State</th><th>Last build</th><th>Source files</th><th></th></tr></thead>
+  <tbody id="codelabs"></tbody>
+</table>
+<script>
+(function() {
+  var building = {};
+  function cell(row, text, className) {
+    var td = document.createElement('td');
+    if (className) {
+      td.className = className;
+    }
+    if (text instanceof Node) {
+      td.appendChild(text);
+    } else {
+      td.textContent = text || '';
+    }
+    row.appendChild(td);
+    return td;
+  }
+  function render(status) {
+    var tbody = document.getElementById('codelabs');
+    tbody.innerHTML = '';
+    var failed = 0;
+    status.codelabs.forEach(function(c) {
+      var row = document.createElement('tr');
+      var name = document.createElement('div');
+      if (c.url) {
+        var a = document.createElement('a');
+        a.href = '` + consts.ServeRootURL + `' + c.url;
+        a.textContent = c.title || c.id;
+        name.appendChild(a);
+      } else {
+        name.textContent = c.id || c.ref;
+      }
+      var ref = document.createElement('pre');
+      ref.textContent = c.ref;
+      name.appendChild(ref);
+      cell(row, name);
+      cell(row, c.source);
+
+      var state = c.error ? 'failed' : 'ok';
+      if (c.id && building[c.id]) {
+        state = 'building';
+      }
+      if (c.error) {
+        failed++;
+        var e = document.createElement('div');
+        e.textContent = 'failed: ' + c.error.stage + ' failed on ' + c.error.file;
+        var message = document.createElement('pre');
+        message.textContent = c.error.message;
+        e.appendChild(message);
+        cell(row, e, 'state');
+      } else {
+        cell(row, state, 'state');
+      }
+      row.className = state;
+      cell(row, c.lastBuild ? new Date(c.lastBuild).toLocaleString() + ' (' + c.durationMs + ' ms)' : '');
+      cell(row, c.files.concat(c.remote || []).join('\n'), 'files');
+
+      var button = document.createElement('button');
+      button.textContent = 'Rebuild';
+      button.onclick = function() {
+        var xhr = new XMLHttpRequest();
+        xhr.onload = function() {
+          if (xhr.status !== 202) {
+            alert('Couldn\'t rebuild ' + c.ref + ': ' + xhr.responseText);
+          }
+        };
+        xhr.open('POST', '` + controlRebuildURL + `?codelab=' + encodeURIComponent(c.ref));
+        xhr.send();
+      };
+      cell(row, button);
+      tbody.appendChild(row);
+    });
+    document.getElementById('summary').textContent = status.codelabs.length + ' codelabs, ' + failed + ' failing';
+    document.getElementById('api').textContent = status.apiError ?
+      'API generation failed on ' + status.apiError.file + ': ' + status.apiError.message : '';
+  }
+  function refresh() {
+    var xhr = new XMLHttpRequest();
+    xhr.onload = function() { render(JSON.parse(xhr.responseText)); };
+    xhr.open('GET', '` + statusJSONURL + `');
+    xhr.send();
+  }
+  function connect() {
+    var ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/reload');
+    // refresh once connected, to not miss any change
+    ws.onopen = refresh;
+    ws.onmessage = function(e) {
+      var msg;
+      try {
+        msg = JSON.parse(e.data);
+      } catch (err) {
+        // plain codelab urls to reload
+        refresh();
+        return;
+      }
+      if (msg.type === 'build-started') {
+        (msg.codelabs || []).forEach(function(id) { building[id] = true; });
+      } else if (msg.type === 'build-failed' || msg.type === 'build-succeeded') {
+        (msg.codelabs || []).forEach(function(id) { delete building[id]; });
+      } else if (msg.type !== 'reload' && msg.type !== 'api-updated') {
+        return;
+      }
+      refresh();
+    };
+    ws.onclose = function() { setTimeout(connect, 1000); };
+  }
+  connect();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+func TestStatus(t *testing.T) {
+	ws := newWorkspace("", nil)
+
+	a := codelab.Codelab{RefURI: "a.md", FilesWatched: []string{"a.md", "img/a.png"}}
+	a.ID, a.Title, a.URL = "codelab-a", "Codelab A", "codelab-a"
+	b := codelab.Codelab{RefURI: "gdoc:b"}
+	b.ID, b.URL = "codelab-b", "codelab-b"
+	start := time.Now().Add(-time.Second)
+	ws.recordBuild("a.md", start)
+	ws.recordBuild("c.md", start)
+	ws.recordBuild("removed.md", start)
+	ws.setBuildError("gdoc:b", &b, errors.New("b failed"))
+	ws.setBuildError("c.md", nil, errors.New("c failed"))
+	ws.setAPIError("metadata", errors.New("api failed"))
+	ws.update([]codelab.Codelab{a, b}, nil)
+
+	s := ws.status()
+
+	want := []codelabStatus{
+		{Ref: "a.md", Source: markdownSource, ID: "codelab-a", Title: "Codelab A", URL: "codelab-a",
+			Files: []string{"a.md", "img/a.png"}, LastBuild: &start},
+		{Ref: "c.md", Source: markdownSource, Files: []string{}, LastBuild: &start,
+			Error: &websocket.BuildError{Codelab: "c.md", File: "c.md", Stage: "build", Message: "c failed"}},
+		{Ref: "gdoc:b", Source: gdocSource, ID: "codelab-b", URL: "codelab-b", Files: []string{},
+			Error: &websocket.BuildError{Codelab: "codelab-b", File: "gdoc:b", Stage: "build", Message: "b failed"}},
+	}
+	for i := range s.Codelabs {
+		if s.Codelabs[i].LastBuild != nil && s.Codelabs[i].DurationMs < 1000 {
+			t.Errorf("got %d ms for %s build; want at least 1000", s.Codelabs[i].DurationMs, s.Codelabs[i].Ref)
+		}
+		s.Codelabs[i].DurationMs = 0
+	}
+	if !reflect.DeepEqual(s.Codelabs, want) {
+		t.Errorf("got %+v; want %+v", s.Codelabs, want)
+	}
+	wantAPI := &websocket.BuildError{File: "metadata", Stage: apiStage, Message: "api failed"}
+	if !reflect.DeepEqual(s.APIError, wantAPI) {
+		t.Errorf("got API error %+v; want %+v", s.APIError, wantAPI)
+	}
+}
+
+func TestServeStatusJSON(t *testing.T) {
+	ws := newWorkspace("", nil)
+	rec := httptest.NewRecorder()
+	ws.serveStatusJSON(rec, httptest.NewRequest("GET", statusJSONURL, nil))
+
+	var s serveStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Couldn't decode %s: %v", rec.Body.String(), err)
+	}
+	if s.Hub == nil {
+		t.Errorf("got %s; want hub counters", rec.Body.String())
+	}
+	// debug handlers mustn't be served on the public port, they could leak the command line and its token
+	if h, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/debug/vars", nil)); pattern != "" {
+		t.Errorf("got %T handling /debug/vars; want none", h)
+	}
+}
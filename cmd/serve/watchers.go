@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"sync"
 
@@ -14,118 +19,509 @@ import (
 	"github.com/ubuntu/tutorial-deployment/codelab"
 	"github.com/ubuntu/tutorial-deployment/internaltools"
 	"github.com/ubuntu/tutorial-deployment/paths"
+	"github.com/ubuntu/tutorial-deployment/websocket"
 )
 
-// watchTrigger is a list of codelab to triggers when an event happen on a file
-type watchTrigger []*codelab.Codelab
+// watchTrigger is a list of codelab references to rebuild when an event happen on a file
+type watchTrigger []string
 
-var (
-	watcher *fsnotify.Watcher
+const (
+	// rebuildDelay is the time without any new change on a codelab before rebuilding it
+	rebuildDelay = 100 * time.Millisecond
 
-	watchedTriggers map[string]watchTrigger
-	watchedDirs     []string
+	// special rebuild targets, on top of codelab references
+	templateTarget   = "<template>"   // rebuild every codelab
+	allTarget        = "<all>"        // rebuild every codelab and regenerate the API, on request
+	metadataTarget   = "<metadata>"   // regenerate the API
+	assetsTarget     = "<assets>"     // copy metadata assets, like event images
+	rediscoverTarget = "<rediscover>" // look for new or removed codelabs
 )
 
-// reisterAllWatchers needs to have all watchers cleaned before up
-func registerAllWatchers() error {
-	if watchedDirs != nil {
-		log.Fatalf("Programer critical error. watchedDirs should be empty before calling registerAllWatchers. Got: %v", watchedDirs)
+// updateWatchers watches every directory containing files of served or failing codelabs, metadata or
+// tutorial paths and unwatches the ones which aren't needed anymore.
+func (ws *workspace) updateWatchers() error {
+	triggers := make(map[string]watchTrigger)
+	var dirs []string
+	for _, c := range ws.known() {
+		for _, f := range c.FilesWatched {
+			triggers[f] = append(triggers[f], c.RefURI)
+			dirs = append(dirs, path.Dir(f))
+		}
+	}
+	// watch tutorial paths for new or removed codelabs and metadata for API and template changes
+	p := paths.New()
+	subdirs, err := subDirs(append([]string{p.MetaData}, p.TutorialInputs...))
+	if err != nil {
+		return err
+	}
+	dirs = append(dirs, subdirs...)
+	// watch closest existing parent of removed directories to get notified of their recreation
+	for i, dir := range dirs {
+		dirs[i] = existingAncestor(dir)
 	}
+	dirs = internaltools.UniqueStrings(dirs)
 
-	watchedTriggers = make(map[string]watchTrigger)
-	for k := range codelabs {
-		// get the reference, and not the copy for codelab
-		c := &codelabs[k]
-		for _, f := range c.FilesWatched {
-			m := watchedTriggers[f]
-			m = append(m, c)
-			watchedTriggers[f] = m
-			watchedDirs = append(watchedDirs, path.Dir(f))
+	ws.muWatch.Lock()
+	defer ws.muWatch.Unlock()
+	ws.triggers = triggers
+
+	wanted := make(map[string]bool)
+	for _, dir := range dirs {
+		wanted[dir] = true
+	}
+	var watched []string
+	for _, dir := range ws.dirs {
+		if wanted[dir] {
+			watched = append(watched, dir)
+			delete(wanted, dir)
+			continue
+		}
+		if err := ws.watcher.Remove(dir); err != nil {
+			// removed directories are already unwatched
+			if _, errStat := os.Stat(dir); !os.IsNotExist(errStat) {
+				log.Printf("Couldn't unwatch %s: %v", dir, err)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		if !wanted[dir] {
+			continue
+		}
+		if errAdd := ws.watcher.Add(dir); errAdd != nil {
+			// we'll retry on next update
+			err = fmt.Errorf("Couldn't watch %s: %v", dir, errAdd)
+			continue
+		}
+		watched = append(watched, dir)
+	}
+	ws.dirs = watched
+	return err
+}
+
+// existingAncestor returns the path itself if it exists or its closest existing parent
+func existingAncestor(p string) string {
+	for {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return p
+		}
+		p = parent
+	}
+}
+
+// forgetWatch removes a directory from the watched ones when it's removed or renamed, as its
+// watch is lost. This will watch it again on next update once it's recreated.
+func (ws *workspace) forgetWatch(dir string) {
+	ws.muWatch.Lock()
+	defer ws.muWatch.Unlock()
+	for i, d := range ws.dirs {
+		if d == dir {
+			ws.watcher.Remove(dir)
+			ws.dirs = append(ws.dirs[:i], ws.dirs[i+1:]...)
+			return
+		}
+	}
+}
+
+// subDirs returns all directories, recursively, of given paths. Files are returning their parent directory.
+func subDirs(roots []string) ([]string, error) {
+	var dirs []string
+	for _, in := range roots {
+		fi, err := os.Stat(in)
+		if os.IsNotExist(err) {
+			// watch for its creation
+			dirs = append(dirs, existingAncestor(in))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't stat: %s", err)
+		}
+		if !fi.IsDir() {
+			dirs = append(dirs, path.Dir(in))
+			continue
+		}
+		if err := filepath.Walk(in, func(p string, i os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if i.IsDir() {
+				dirs = append(dirs, p)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
 		}
 	}
-	watchedDirs = internaltools.UniqueStrings(watchedDirs)
-	return watchdirs()
+	return dirs, nil
 }
 
-func listenForChanges(wg *sync.WaitGroup, stop <-chan struct{}) {
-	wg.Add(1)
+// listenForChanges schedules rebuilds on file changes until stop is closed. It returns the scheduler
+// for rebuilds to be requested from elsewhere.
+func (ws *workspace) listenForChanges(wg *sync.WaitGroup, stop <-chan struct{}) *rebuildScheduler {
+	s := newRebuildScheduler(rebuildDelay, ws.rebuild)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.run(stop)
+	}()
 	go func() {
 		defer wg.Done()
-		defer watcher.Close()
-		p := paths.New()
-		for {
-			select {
-			case event := <-watcher.Events:
-				if event.Op&fsnotify.Write == fsnotify.Write ||
-					event.Op&fsnotify.Remove == fsnotify.Remove ||
-					event.Op&fsnotify.Create == fsnotify.Create {
-					// small delay because sometimes the whole source isn't flushed out to disk yet
-					<-time.After(10 * time.Millisecond)
-					cs := impactedCodelabs(event.Name)
-					if err := refreshCodelabs(cs, *p); err != nil {
-						log.Print(err)
-						// do not send refresh signal
-						continue
-					}
-					for _, c := range cs {
-						hub.Send([]byte(c.URL))
-					}
-				}
-
-			case err := <-watcher.Errors:
-				log.Println("Watch error:", err)
-
-			case <-stop:
-				return
+		defer ws.watcher.Close()
+		ws.watchEvents(s, stop)
+	}()
+	return s
+}
+
+// watchEvents schedules rebuilds from watcher events until stop is closed.
+// Editors saving by renaming a temporary file over the original one, or by removing and
+// creating it again, are generating multiple events which are merged by the scheduler.
+func (ws *workspace) watchEvents(s *rebuildScheduler, stop <-chan struct{}) {
+	p := paths.New()
+	for {
+		select {
+		case event := <-ws.watcher.Events():
+			// any removed or renamed watched directory isn't watched anymore
+			if event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename {
+				ws.forgetWatch(event.Name)
+			}
+			if targets := ws.rebuildTargets(event, *p); len(targets) > 0 {
+				s.schedule(targets...)
 			}
+
+		case err := <-ws.watcher.Errors():
+			log.Println("Watch error:", err)
+
+		case <-stop:
+			return
 		}
-	}()
+	}
 }
 
-func watchdirs() error {
-	for _, dir := range watchedDirs {
-		if err := watcher.Add(dir); err != nil {
-			return err
+// rebuildTargets returns what needs to be rebuilt after a file event
+func (ws *workspace) rebuildTargets(event fsnotify.Event, p paths.Path) []string {
+	if event.Name == ws.template {
+		return []string{templateTarget}
+	}
+	if isInPaths(event.Name, []string{p.MetaData}) {
+		switch filepath.Ext(event.Name) {
+		case ".yaml", ".yml", "":
+			return []string{metadataTarget}
 		}
+		return []string{assetsTarget}
 	}
-	return nil
+
+	ws.muWatch.RLock()
+	refs, watched := ws.triggers[event.Name]
+	refs = append(watchTrigger(nil), refs...)
+	// a created, removed or renamed directory impacts all codelabs having files in it
+	if event.Op&fsnotify.Write != fsnotify.Write && event.Op&fsnotify.Chmod != fsnotify.Chmod {
+		for f, t := range ws.triggers {
+			if isInPaths(f, []string{event.Name}) && f != event.Name {
+				refs = append(refs, t...)
+			}
+		}
+	}
+	ws.muWatch.RUnlock()
+
+	targets := internaltools.UniqueStrings(refs)
+	if needsRediscovery(event, p, watched) {
+		targets = append(targets, rediscoverTarget)
+	}
+	return targets
+}
+
+// needsRediscovery returns true if the event can add or remove codelabs: any file created, removed or
+// renamed in tutorial paths, or any change to a file which isn't a known codelab source (like gdoc.def).
+func needsRediscovery(event fsnotify.Event, p paths.Path, watched bool) bool {
+	if !isInPaths(event.Name, p.TutorialInputs) {
+		return false
+	}
+	if event.Op&fsnotify.Create == fsnotify.Create ||
+		event.Op&fsnotify.Remove == fsnotify.Remove ||
+		event.Op&fsnotify.Rename == fsnotify.Rename {
+		return true
+	}
+	return event.Op&fsnotify.Write == fsnotify.Write && !watched
+}
+
+// isInPaths returns true if the file is one of the paths or in one of their directories
+func isInPaths(f string, roots []string) bool {
+	for _, in := range roots {
+		if f == in || strings.HasPrefix(f, in+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildResult is what changed during a rebuild
+type buildResult struct {
+	changed []codelab.Codelab // added, removed and rebuilt codelabs
+	built   []string          // successfully built codelab names
+	failed  []string          // failed codelab names
+	stale   bool              // the API couldn't be regenerated: browsers are notified of changes once it is
 }
 
-func refreshCodelabs(cs []*codelab.Codelab, p paths.Path) error {
+// rebuild looks for new or removed codelabs, rebuilds codelabs and regenerates the API depending on targets.
+// Browsers are notified when the build starts and ends, with any build error. They are asked to reload
+// codelabs which built successfully once the API is regenerated, if the rebuild wasn't cancelled.
+func (ws *workspace) rebuild(ctx context.Context, targets []string) error {
+	p := paths.New()
 	defer func() {
-		if err := registerAllWatchers(); err != nil {
+		if err := ws.updateWatchers(); err != nil {
 			log.Printf("Couldn't watch dirs: %v", err)
 		}
 	}()
-	if err := unwatchdirs(); err != nil {
-		return fmt.Errorf("Couldn't unwatch all dirs: %v", err)
+
+	t := make(map[string]bool)
+	for _, target := range targets {
+		t[target] = true
+	}
+	var started []string
+	for _, c := range ws.known() {
+		if !rebuildsAll(t) && !t[c.RefURI] {
+			continue
+		}
+		// failing codelabs may not have any ID yet
+		if c.ID == "" {
+			started = append(started, c.RefURI)
+			continue
+		}
+		started = append(started, c.ID)
+	}
+	notify(websocket.NewMessage(websocket.BuildStartedMessage, started...))
+
+	r, err := ws.build(ctx, t, *p)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// newer changes will be built and reported, along with the ones this rebuild already made
+		ws.postpone(r.changed)
+		return ctxErr
+	}
+	notify(ws.buildStatusMessage(err != nil || len(r.failed) > 0, append(r.built, r.failed...)...))
+	if r.stale {
+		// browsers will be notified of those changes once the API is regenerated
+		ws.postpone(r.changed)
+		return err
+	}
+	ws.notifyChanges(t, r)
+	return err
+}
+
+// build rediscovers and rebuilds codelabs, then regenerates the API, depending on targets.
+// Codelabs are rebuilt from copies, replacing served ones once built. A failing codelab doesn't prevent
+// others from being built: all failures are returned once the API is regenerated. Changes of cancelled
+// rebuilds are part of the result.
+func (ws *workspace) build(ctx context.Context, t map[string]bool, p paths.Path) (buildResult, error) {
+	var r buildResult
+	r.changed = ws.takePostponed()
+	refreshAPI := t[metadataTarget] || t[assetsTarget] || t[allTarget] || len(r.changed) > 0
+
+	// failing codelabs to build again, unless they are removed. New ones are built by the rediscovery.
+	retried := make(map[string]bool)
+	for _, c := range ws.failingCodelabs() {
+		if rebuildsAll(t) || t[c.RefURI] {
+			retried[c.RefURI] = true
+		}
+	}
+
+	if t[rediscoverTarget] {
+		changed, failed, err := ws.rediscover(p)
+		if err != nil {
+			r.stale = true
+			return r, err
+		}
+		r.changed = append(r.changed, changed...)
+		r.failed = append(r.failed, failed...)
+		refreshAPI = refreshAPI || len(changed) > 0
 	}
+
+	fixed, failed := ws.retryFailing(retried, p)
+	for _, c := range fixed {
+		r.built = append(r.built, c.ID)
+	}
+	r.changed = append(r.changed, fixed...)
+	r.failed = append(r.failed, failed...)
+	refreshAPI = refreshAPI || len(fixed) > 0
+
+	var cs []codelab.Codelab
+	for _, c := range ws.served() {
+		if rebuildsAll(t) || t[c.RefURI] {
+			cs = append(cs, c)
+		}
+	}
+	if t[templateTarget] {
+		log.Printf("Template changed: rebuilding all codelabs")
+	} else if t[allTarget] {
+		log.Printf("Rebuilding all codelabs")
+	}
+	var errs []string
 	for _, c := range cs {
-		if err := c.Refresh(); err != nil {
-			return fmt.Errorf("Couldn't refresh successfully %s: %v", c.RefURI, err)
+		if err := ctx.Err(); err != nil {
+			return r, err
 		}
+		start := time.Now()
+		err := c.Refresh()
+		ws.recordBuild(c.RefURI, start)
+		// its files to watch changed, even on failure
+		ws.replace(c)
+		if err != nil {
+			r.failed = append(r.failed, ws.setBuildError(c.RefURI, &c, err))
+			errs = append(errs, fmt.Sprintf("Couldn't refresh successfully %s: %v", c.RefURI, err))
+			continue
+		}
+		ws.clearBuildError(c.RefURI)
+		r.changed = append(r.changed, c)
+		r.built = append(r.built, c.ID)
+		refreshAPI = true
 	}
-	if err := refreshAPIs(codelabs, p.API); err != nil {
-		return fmt.Errorf("Couldn't refresh: %s", err)
+
+	if refreshAPI {
+		if err := refreshAPIs(ws.served(), p.API); err != nil {
+			ws.setAPIError(p.MetaData, err)
+			r.stale = true
+			errs = append(errs, fmt.Sprintf("Couldn't refresh: %s", err))
+		} else {
+			ws.clearBuildError(apiErrorRef)
+		}
+	}
+	if len(errs) > 0 {
+		return r, errors.New(strings.Join(errs, "\n"))
 	}
+	return r, nil
+}
 
-	return nil
+// rebuildsAll returns true if every codelab needs to be rebuilt
+func rebuildsAll(t map[string]bool) bool {
+	return t[templateTarget] || t[allTarget]
 }
 
-func impactedCodelabs(file string) []*codelab.Codelab {
-	w, ok := watchedTriggers[file]
-	if !ok {
-		return nil
+// notify sends a message to browsers, unless they only understand plain reload messages
+func notify(m websocket.Message) {
+	if !jsonMessages {
+		return
 	}
-	return []*codelab.Codelab(w)
+	hub.Publish(m)
 }
 
-func unwatchdirs() error {
-	for _, dir := range watchedDirs {
-		if err := watcher.Remove(dir); err != nil {
-			return err
+// notifyChanges asks browsers to reload changed codelabs and refresh API or assets
+func (ws *workspace) notifyChanges(t map[string]bool, r buildResult) {
+	ids, urls := changedCodelabs(r.changed)
+
+	if !jsonMessages {
+		// any metadata or template change impacts every codelab
+		if t[metadataTarget] || t[assetsTarget] || rebuildsAll(t) {
+			urls = nil
+			for _, c := range ws.served() {
+				urls = append(urls, c.URL)
+			}
+		}
+		for _, url := range internaltools.UniqueStrings(urls) {
+			hub.Send([]byte(url))
 		}
+		return
+	}
+
+	if len(ids) > 0 {
+		m := websocket.NewMessage(websocket.ReloadMessage, ids...)
+		m.URLs = urls
+		notify(m)
+	}
+	if t[metadataTarget] || len(ids) > 0 {
+		notify(websocket.NewMessage(websocket.APIUpdatedMessage, ids...))
+	}
+	if t[assetsTarget] {
+		notify(websocket.NewMessage(websocket.AssetUpdatedMessage))
+	}
+}
+
+// changedCodelabs returns the IDs and urls of changed codelabs. Language variants share their ID, but have
+// their own url for browsers to only reload the variant which changed.
+func changedCodelabs(cs []codelab.Codelab) (ids, urls []string) {
+	for _, c := range cs {
+		ids = append(ids, c.ID)
+		urls = append(urls, c.URL)
+	}
+	return internaltools.UniqueStrings(ids), internaltools.UniqueStrings(urls)
+}
+
+// rediscover builds new codelabs and removes deleted ones from tutorial paths.
+// It returns all added and removed codelabs, and the names of new failing ones.
+func (ws *workspace) rediscover(p paths.Path) ([]codelab.Codelab, []string, error) {
+	refs, err := codelab.Discover()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Couldn't detect codelabs: %s", err)
+	}
+	discovered := make(map[string]bool)
+	for _, ref := range refs {
+		discovered[ref] = true
+	}
+	ws.forgetBuildErrors(discovered)
+
+	var changed, kept, keptFailing []codelab.Codelab
+	known := make(map[string]bool)
+	for _, c := range ws.failingCodelabs() {
+		known[c.RefURI] = true
+		if discovered[c.RefURI] {
+			keptFailing = append(keptFailing, c)
+			continue
+		}
+		// nothing was served
+		log.Printf("%s was removed", c.RefURI)
+	}
+	for _, c := range ws.served() {
+		known[c.RefURI] = true
+		if discovered[c.RefURI] {
+			kept = append(kept, c)
+			continue
+		}
+		log.Printf("%s was removed", c.RefURI)
+		if err := c.Remove(); err != nil {
+			log.Printf("Couldn't remove generated content for %s: %v", c.RefURI, err)
+		}
+		changed = append(changed, c)
+	}
+	var newRefs []string
+	for _, ref := range refs {
+		if !known[ref] {
+			newRefs = append(newRefs, ref)
+		}
+	}
+
+	// errors are logged and failing codelabs will be retried on next change
+	added, failing, failed := ws.buildCodelabs(newRefs, p.Export)
+	for _, c := range added {
+		log.Printf("%s was added", c.RefURI)
+	}
+	changed = append(changed, added...)
+	ws.update(append(kept, added...), append(keptFailing, failing...))
+
+	return changed, failed, nil
+}
+
+// retryFailing builds again failing codelabs among refs. Fixed ones are served from now on and
+// returned, along with the names of those still failing.
+func (ws *workspace) retryFailing(refs map[string]bool, p paths.Path) ([]codelab.Codelab, []string) {
+	var retried []string
+	var kept []codelab.Codelab
+	for _, c := range ws.failingCodelabs() {
+		if refs[c.RefURI] {
+			retried = append(retried, c.RefURI)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if len(retried) == 0 {
+		return nil, nil
+	}
+
+	fixed, failing, failed := ws.buildCodelabs(retried, p.Export)
+	for _, c := range fixed {
+		log.Printf("%s was fixed", c.RefURI)
 	}
-	watchedDirs = nil
-	return nil
+	ws.update(append(ws.served(), fixed...), append(kept, failing...))
+	return fixed, failed
 }
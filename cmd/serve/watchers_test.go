@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/paths"
+	"github.com/ubuntu/tutorial-deployment/testtools"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+const testPollInterval = 5 * time.Millisecond
+
+func TestEditorSavePatterns(t *testing.T) {
+	testCases := []struct {
+		name string
+		save func(t *testing.T, f, content string)
+	}{
+		{"write in place", func(t *testing.T, f, content string) {
+			writeFile(t, f, content)
+		}},
+		{"rename temporary file over original", func(t *testing.T, f, content string) {
+			writeFile(t, f+".tmp", content)
+			rename(t, f+".tmp", f)
+		}},
+		{"vim: rename original to backup, write new file, remove backup", func(t *testing.T, f, content string) {
+			rename(t, f, f+"~")
+			writeFile(t, f, content)
+			remove(t, f+"~")
+		}},
+		{"jetbrains: write temporary file, rename original, rename temporary file, remove original", func(t *testing.T, f, content string) {
+			writeFile(t, f+"___jb_tmp___", content)
+			rename(t, f, f+"___jb_old___")
+			rename(t, f+"___jb_tmp___", f)
+			remove(t, f+"___jb_old___")
+		}},
+		{"delete then create", func(t *testing.T, f, content string) {
+			remove(t, f)
+			writeFile(t, f, content)
+		}},
+		{"change permissions only", func(t *testing.T, f, content string) {
+			fi, err := os.Stat(f)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			mode := os.FileMode(0600)
+			if fi.Mode().Perm() == mode {
+				mode = 0644
+			}
+			if err := os.Chmod(f, mode); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}},
+	}
+	for _, mode := range []string{notifyWatchMode, pollWatchMode} {
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s: %s", mode, tc.name), func(t *testing.T) {
+				for _, inTutorialPath := range []bool{true, false} {
+					ref, files, calls, teardown := setupWatch(t, mode)
+					f := files[0]
+					if !inTutorialPath {
+						f = files[1]
+					}
+
+					tc.save(t, f, "new content")
+					wantRebuildOf(t, calls, ref)
+
+					// following saves are still detected
+					waitNoMoreRebuild(calls)
+					tc.save(t, f, "newer content")
+					wantRebuildOf(t, calls, ref)
+
+					teardown()
+				}
+			})
+		}
+	}
+}
+
+func TestWatchRecreatedDirectories(t *testing.T) {
+	for _, mode := range []string{notifyWatchMode, pollWatchMode} {
+		t.Run(mode, func(t *testing.T) {
+			for _, inTutorialPath := range []bool{true, false} {
+				ref, files, calls, teardown := setupWatch(t, mode)
+				// an image in a directory, either in tutorial path or outside
+				f := files[2]
+				if !inTutorialPath {
+					f = files[3]
+				}
+				dir := filepath.Dir(f)
+
+				if err := os.RemoveAll(dir); err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				wantRebuildOf(t, calls, ref)
+				waitNoMoreRebuild(calls)
+
+				// recreate it, like a version control system would do
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				writeFile(t, f, "image content")
+				wantRebuildOf(t, calls, ref)
+				waitNoMoreRebuild(calls)
+
+				// watch is restored
+				writeFile(t, f, "new image content")
+				wantRebuildOf(t, calls, ref)
+
+				teardown()
+			}
+		})
+	}
+}
+
+func TestMetadataRebuildTargets(t *testing.T) {
+	p := paths.Path{MetaData: "/site/metadata"}
+	ws := newWorkspace("/site/metadata/ubuntu-template.html", nil)
+
+	testCases := []struct {
+		file string
+
+		want []string
+	}{
+		{"/site/metadata/ubuntu-template.html", []string{templateTarget}},
+		{"/site/metadata/events.yaml", []string{metadataTarget}},
+		{"/site/metadata/categories.yml", []string{metadataTarget}},
+		{"/site/metadata/subdir", []string{metadataTarget}},
+		{"/site/metadata/event.jpg", []string{assetsTarget}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("targets for %s", tc.file), func(t *testing.T) {
+			got := ws.rebuildTargets(fsnotify.Event{Name: tc.file, Op: fsnotify.Write}, p)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChangedCodelabs(t *testing.T) {
+	variant := func(id, lang string) codelab.Codelab {
+		c := codelab.Codelab{RefURI: id + "." + lang + ".md", Language: lang}
+		c.ID, c.URL = id, id
+		if lang != "" {
+			c.URL += "/" + lang
+		}
+		return c
+	}
+
+	testCases := []struct {
+		changed []codelab.Codelab
+
+		wantIDs  []string
+		wantURLs []string
+	}{
+		{nil, nil, nil},
+		{[]codelab.Codelab{variant("a", "")}, []string{"a"}, []string{"a"}},
+		{[]codelab.Codelab{variant("a", "fr")}, []string{"a"}, []string{"a/fr"}},
+		{[]codelab.Codelab{variant("a", ""), variant("a", "fr"), variant("b", "")}, []string{"a", "b"}, []string{"a", "a/fr", "b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("changes of %d codelabs", len(tc.changed)), func(t *testing.T) {
+			ids, urls := changedCodelabs(tc.changed)
+			if !reflect.DeepEqual(ids, tc.wantIDs) {
+				t.Errorf("got ids %v; want %v", ids, tc.wantIDs)
+			}
+			if !reflect.DeepEqual(urls, tc.wantURLs) {
+				t.Errorf("got urls %v; want %v", urls, tc.wantURLs)
+			}
+		})
+	}
+}
+
+func TestRetryFailingCodelabs(t *testing.T) {
+	root, teardown := testtools.TempDir(t)
+	defer teardown()
+	ws := newWorkspace(filepath.Join(root, "template.html"), nil)
+	writeFile(t, ws.template, "{{.Title}}")
+	ref := filepath.Join(root, "codelab.md")
+	image := filepath.Join(root, "foo.png")
+	writeFile(t, ref, "---\nid: codelab\n\n---\n\n# Codelab\n\n## Step\n\n![foo](foo.png)\n")
+	p := paths.Path{Export: filepath.Join(root, "export")}
+
+	built, failing, failed := ws.buildCodelabs([]string{ref}, p.Export)
+	if len(built) != 0 || len(failing) != 1 || !reflect.DeepEqual(failed, []string{"codelab"}) {
+		t.Fatalf("got %d built, %d failing named %v; want the codelab to fail", len(built), len(failing), failed)
+	}
+	if want := []string{ref, image}; !reflect.DeepEqual(failing[0].FilesWatched, want) {
+		t.Errorf("got files watched %v; want %v to be watched for a fix", failing[0].FilesWatched, want)
+	}
+	ws.update(nil, failing)
+
+	// not requested
+	if fixed, failed := ws.retryFailing(map[string]bool{"other.md": true}, p); fixed != nil || failed != nil {
+		t.Errorf("got %d fixed, %v failed; want none to be retried", len(fixed), failed)
+	}
+
+	// still failing
+	fixed, failed := ws.retryFailing(map[string]bool{ref: true}, p)
+	if len(fixed) != 0 || !reflect.DeepEqual(failed, []string{"codelab"}) || len(ws.failingCodelabs()) != 1 {
+		t.Errorf("got %d fixed, %v failed, %d failing; want the codelab to still fail", len(fixed), failed, len(ws.failingCodelabs()))
+	}
+
+	// fixed
+	writeFile(t, image, "image")
+	fixed, failed = ws.retryFailing(map[string]bool{ref: true}, p)
+	if len(fixed) != 1 || failed != nil {
+		t.Fatalf("got %d fixed, %v failed; want the codelab to be fixed", len(fixed), failed)
+	}
+	if served := ws.served(); len(served) != 1 || served[0].RefURI != ref || len(ws.failingCodelabs()) != 0 {
+		t.Errorf("got %d codelabs and %d failing ones; want the fixed codelab to be served", len(served), len(ws.failingCodelabs()))
+	}
+	if errs := ws.buildErrors(); len(errs) != 0 {
+		t.Errorf("got build errors %v; want none", errs)
+	}
+}
+
+func TestRediscover(t *testing.T) {
+	ws, p, tutorials, teardown := setupSite(t)
+	defer teardown()
+	a, b := filepath.Join(tutorials, "a.md"), filepath.Join(tutorials, "b.md")
+	writeCodelabSource(t, a, "a")
+	writeCodelabSource(t, b, "b")
+	built, _, failed := ws.buildCodelabs([]string{a, b}, p.Export)
+	if len(built) != 2 || failed != nil {
+		t.Fatalf("got %d built and %v failed; want both codelabs to build", len(built), failed)
+	}
+	ws.update(built, nil)
+	if err := ws.updateWatchers(); err != nil {
+		t.Fatalf("Couldn't watch: %v", err)
+	}
+
+	// nothing changed
+	changed, failed, err := ws.rediscover(*p)
+	if err != nil || len(changed) != 0 || failed != nil {
+		t.Errorf("got %d changed, %v failed, err %v; want no change", len(changed), failed, err)
+	}
+
+	// a is removed, b renamed and c added in a new directory
+	if err := os.Remove(a); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b2 := filepath.Join(tutorials, "b2.md")
+	if err := os.Rename(b, b2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	c := filepath.Join(tutorials, "sub", "c.md")
+	writeCodelabSource(t, c, "c")
+	changed, failed, err = ws.rediscover(*p)
+	if err != nil || failed != nil {
+		t.Fatalf("got %v failed, err %v; want rediscovery to succeed", failed, err)
+	}
+	var changedRefs []string
+	for _, c := range changed {
+		changedRefs = append(changedRefs, c.RefURI)
+	}
+	sort.Strings(changedRefs)
+	if want := []string{a, b, b2, c}; !reflect.DeepEqual(changedRefs, want) {
+		t.Errorf("got changed %v; want %v", changedRefs, want)
+	}
+	var served []string
+	for _, c := range ws.served() {
+		served = append(served, c.RefURI)
+	}
+	sort.Strings(served)
+	if want := []string{b2, c}; !reflect.DeepEqual(served, want) {
+		t.Errorf("got served %v; want %v", served, want)
+	}
+	for f, want := range map[string]bool{"a": false, "b/index.html": true, "c/index.html": true} {
+		if _, err := os.Stat(filepath.Join(p.Export, f)); (err == nil) != want {
+			t.Errorf("%s exported: %v; want %v", f, err == nil, want)
+		}
+	}
+
+	// new directories are watched, even without any codelab in them yet
+	empty := filepath.Join(tutorials, "empty")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if changed, _, _ := ws.rediscover(*p); len(changed) != 0 {
+		t.Errorf("got %d changed; want none", len(changed))
+	}
+	if err := ws.updateWatchers(); err != nil {
+		t.Fatalf("Couldn't watch: %v", err)
+	}
+	for _, dir := range []string{filepath.Join(tutorials, "sub"), empty} {
+		if !testtools.StringContains(ws.dirs, dir) {
+			t.Errorf("%s isn't watched: watching %v", dir, ws.dirs)
+		}
+	}
+}
+
+func TestRebuildCancelledAfterRediscovery(t *testing.T) {
+	ws, p, tutorials, teardown := setupSite(t)
+	defer teardown()
+	messages, teardownHub := listenToHub(t)
+	defer teardownHub()
+
+	// the rediscovery commits the new codelab before the cancellation is noticed
+	writeCodelabSource(t, filepath.Join(tutorials, "a.md"), "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ws.rebuild(ctx, []string{rediscoverTarget}); err != context.Canceled {
+		t.Fatalf("got %v; want the rebuild to be cancelled", err)
+	}
+	if served := ws.served(); len(served) != 1 || served[0].ID != "a" {
+		t.Fatalf("got %d served codelabs; want a to be served", len(served))
+	}
+
+	// the rerun doesn't discover anything new, but reports what the cancelled rebuild added
+	if err := ws.rebuild(context.Background(), []string{rediscoverTarget}); err != nil {
+		t.Fatalf("rebuild() unexpected error: %v", err)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case m := <-messages:
+			if m.Type == websocket.ReloadMessage && !reflect.DeepEqual(m.Codelabs, []string{"a"}) {
+				t.Errorf("got reload of %v; want a to be reloaded", m.Codelabs)
+			}
+			if m.Type != websocket.APIUpdatedMessage {
+				continue
+			}
+			if !reflect.DeepEqual(m.Codelabs, []string{"a"}) {
+				t.Errorf("got api updated for %v; want a", m.Codelabs)
+			}
+			if _, err := os.Stat(filepath.Join(p.API, "codelabs.json")); err != nil {
+				t.Errorf("API wasn't generated: %v", err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("browsers weren't notified of the new codelab")
+		}
+	}
+}
+
+func TestRebuildAfterFailure(t *testing.T) {
+	ws, p, tutorials, teardown := setupSite(t)
+	defer teardown()
+	var refs []string
+	for _, id := range []string{"a", "b", "c"} {
+		ref := filepath.Join(tutorials, id+".md")
+		writeCodelabSource(t, ref, id)
+		refs = append(refs, ref)
+	}
+	built, _, _ := ws.buildCodelabs(refs, p.Export)
+	ws.update(built, nil)
+	messages, teardownHub := listenToHub(t)
+	defer teardownHub()
+
+	// b fails on a missing image while a and c change
+	writeFile(t, refs[0], "---\nid: a\n\n---\n\n# New a\n\n## Step\n")
+	writeFile(t, refs[1], "---\nid: b\n\n---\n\n# Codelab b\n\n## Step\n\n![foo](missing.png)\n")
+	writeFile(t, refs[2], "---\nid: c\n\n---\n\n# New c\n\n## Step\n")
+	err := ws.rebuild(context.Background(), []string{allTarget})
+	if err == nil || !strings.Contains(err.Error(), refs[1]) {
+		t.Errorf("got %v; want an error about %s", err, refs[1])
+	}
+
+	// other codelabs are built and served
+	for _, c := range ws.served() {
+		want := "Codelab b"
+		if c.ID != "b" {
+			want = "New " + c.ID
+		}
+		if c.Title != want {
+			t.Errorf("got title %q for %s; want %q", c.Title, c.ID, want)
+		}
+	}
+	var gotBuildStatus, gotReload bool
+	timeout := time.After(time.Second)
+	for !gotBuildStatus || !gotReload {
+		select {
+		case m := <-messages:
+			switch m.Type {
+			case websocket.BuildFailedMessage:
+				gotBuildStatus = true
+				sort.Strings(m.Codelabs)
+				if want := []string{"a", "b", "c"}; !reflect.DeepEqual(m.Codelabs, want) {
+					t.Errorf("got build status of %v; want %v", m.Codelabs, want)
+				}
+			case websocket.ReloadMessage:
+				gotReload = true
+				sort.Strings(m.Codelabs)
+				if want := []string{"a", "c"}; !reflect.DeepEqual(m.Codelabs, want) {
+					t.Errorf("got reload of %v; want %v", m.Codelabs, want)
+				}
+			}
+		case <-timeout:
+			t.Fatalf("got build status: %v, reload: %v; want both", gotBuildStatus, gotReload)
+		}
+	}
+}
+
+func TestPlainReloadMessages(t *testing.T) {
+	ws := newWorkspace("", nil)
+	a := codelab.Codelab{}
+	a.ID, a.URL = "a", "a"
+	aFr := codelab.Codelab{}
+	aFr.ID, aFr.URL = "a", "a/fr"
+	b := codelab.Codelab{}
+	b.ID, b.URL = "b", "b"
+	ws.update([]codelab.Codelab{a, aFr, b}, nil)
+
+	testCases := []struct {
+		name    string
+		targets []string
+		changed []codelab.Codelab
+
+		want []string
+	}{
+		{"codelab changed", nil, []codelab.Codelab{aFr}, []string{"a/fr"}},
+		{"metadata changed", []string{metadataTarget}, nil, []string{"a", "a/fr", "b"}},
+		{"assets changed", []string{assetsTarget}, nil, []string{"a", "a/fr", "b"}},
+		{"everything rebuilt", []string{allTarget}, []codelab.Codelab{a, b}, []string{"a", "a/fr", "b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			messages, teardown := listenToPlainHub(t)
+			defer teardown()
+
+			targets := make(map[string]bool)
+			for _, target := range tc.targets {
+				targets[target] = true
+			}
+			ws.notifyChanges(targets, buildResult{changed: tc.changed})
+
+			var got []string
+			timeout := time.After(time.Second)
+			for len(got) < len(tc.want) {
+				select {
+				case m := <-messages:
+					got = append(got, m)
+				case <-timeout:
+					t.Fatalf("got %v; want %v", got, tc.want)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// setupSite creates empty tutorial and metadata directories, for codelabs to be discovered, built and
+// served by the returned workspace.
+func setupSite(t *testing.T) (*workspace, *paths.Path, string, func()) {
+	root, teardownDir := testtools.TempDir(t)
+	tutorials := filepath.Join(root, "tutorials")
+	p, teardownPath := paths.MockPath()
+	p.TutorialInputs = []string{tutorials}
+	p.MetaData = filepath.Join(root, "metadata")
+	p.Export = filepath.Join(root, "export")
+	p.API = filepath.Join(root, "api")
+	p.Images = filepath.Join(root, "images")
+	for _, f := range []string{"events.yaml", "categories.yaml"} {
+		if err := os.MkdirAll(p.MetaData, 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		writeFile(t, filepath.Join(p.MetaData, f), "{}")
+	}
+	if err := os.MkdirAll(tutorials, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	watcher, err := newFileWatcher(pollWatchMode, testPollInterval)
+	if err != nil {
+		t.Fatalf("Couldn't create watcher: %v", err)
+	}
+	ws := newWorkspace(filepath.Join(p.MetaData, "template.html"), watcher)
+	writeFile(t, ws.template, "{{.Title}}")
+
+	return ws, p, tutorials, func() {
+		watcher.Close()
+		teardownPath()
+		teardownDir()
+	}
+}
+
+// writeCodelabSource writes a markdown codelab with the given ID, creating its directory if needed
+func writeCodelabSource(t *testing.T, ref, id string) {
+	if err := os.MkdirAll(filepath.Dir(ref), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	writeFile(t, ref, fmt.Sprintf("---\nid: %s\n\n---\n\n# Codelab %s\n\n## Step\n", id, id))
+}
+
+// listenToHub replaces the hub by a running one sending json messages, with a connected client receiving them
+func listenToHub(t *testing.T) (<-chan websocket.Message, func()) {
+	orig := jsonMessages
+	jsonMessages = true
+	raw, teardown := listenToPlainHub(t)
+
+	messages := make(chan websocket.Message, 100)
+	go func() {
+		for data := range raw {
+			var m websocket.Message
+			if err := json.Unmarshal([]byte(data), &m); err != nil {
+				t.Errorf("Couldn't decode %q: %v", data, err)
+				continue
+			}
+			messages <- m
+		}
+	}()
+	return messages, func() {
+		teardown()
+		jsonMessages = orig
+	}
+}
+
+// listenToPlainHub replaces the hub by a running one, with a connected client receiving its raw messages
+func listenToPlainHub(t *testing.T) (<-chan string, func()) {
+	orig := hub
+	h := websocket.NewHub()
+	hub = h
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.Run()
+	}()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+	conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Couldn't connect to hub: %v", err)
+	}
+	// wait for registration
+	for h.Stats().Clients == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	messages := make(chan string, 100)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(messages)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(data)
+		}
+	}()
+	return messages, func() {
+		conn.Close()
+		h.Stop()
+		ts.Close()
+		wg.Wait()
+		hub = orig
+	}
+}
+
+// setupWatch creates a codelab with files in and outside tutorial paths, watches them and reports
+// rebuild targets.
+// Watched files are: the codelab source, an image next to it, an image in a sub directory
+// and an image in a sub directory outside of tutorial paths.
+// mode selects the file watcher backend.
+func setupWatch(t *testing.T, mode string) (string, []string, <-chan []string, func()) {
+	root, teardownDir := testtools.TempDir(t)
+	tutorials := filepath.Join(root, "tutorials")
+	metadata := filepath.Join(root, "metadata")
+	ref := filepath.Join(tutorials, "codelab.md")
+	files := []string{
+		ref,
+		filepath.Join(root, "foo.png"),
+		filepath.Join(tutorials, "img", "bar.png"),
+		filepath.Join(root, "shared", "img", "baz.png"),
+	}
+	for _, f := range append(files, filepath.Join(metadata, "template.html")) {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		writeFile(t, f, "content")
+	}
+
+	p, teardownPath := paths.MockPath()
+	p.TutorialInputs = []string{tutorials}
+	p.MetaData = metadata
+	watcher, err := newFileWatcher(mode, testPollInterval)
+	if err != nil {
+		t.Fatalf("Couldn't create watcher: %v", err)
+	}
+	ws := newWorkspace(filepath.Join(metadata, "template.html"), watcher)
+	ws.update([]codelab.Codelab{codelab.Codelab{RefURI: ref, FilesWatched: files}}, nil)
+	if err := ws.updateWatchers(); err != nil {
+		t.Fatalf("Couldn't watch: %v", err)
+	}
+
+	calls := make(chan []string, 100)
+	s := newRebuildScheduler(testDelay, func(ctx context.Context, targets []string) error {
+		calls <- targets
+		return ws.updateWatchers()
+	})
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.run(stop)
+	}()
+	go func() {
+		defer wg.Done()
+		ws.watchEvents(s, stop)
+	}()
+
+	return ref, files, calls, func() {
+		close(stop)
+		wg.Wait()
+		watcher.Close()
+		teardownPath()
+		teardownDir()
+	}
+}
+
+func wantRebuildOf(t *testing.T, calls <-chan []string, ref string) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case targets := <-calls:
+			if testtools.StringContains(targets, ref) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s wasn't rebuilt", ref)
+		}
+	}
+}
+
+// waitNoMoreRebuild drains rebuilds until none happens anymore
+func waitNoMoreRebuild(calls <-chan []string) {
+	for {
+		select {
+		case <-calls:
+		case <-time.After(5 * testDelay):
+			return
+		}
+	}
+}
+
+func writeFile(t *testing.T, f, content string) {
+	if err := ioutil.WriteFile(f, []byte(content), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func rename(t *testing.T, src, dst string) {
+	if err := os.Rename(src, dst); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func remove(t *testing.T, f string) {
+	if err := os.Remove(f); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
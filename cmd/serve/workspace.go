@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+	"github.com/ubuntu/tutorial-deployment/websocket"
+)
+
+// workspace owns the served and failing codelabs, their watches and their build state.
+// Codelabs are only changed by rebuilds, which the scheduler runs one at a time: they build copies and
+// commit them once done. Http handlers, pollers and the event loop only get copies.
+type workspace struct {
+	template string // codelab template, every codelab is rebuilt when it changes
+
+	// mu protects codelabs and their build state
+	mu       sync.RWMutex
+	codelabs []codelab.Codelab               // served codelabs
+	failing  []codelab.Codelab               // codelabs which never built successfully, watched until they are fixed
+	errors   map[string]websocket.BuildError // current errors per codelab reference, and of the API
+	builds   map[string]buildTime            // last build per codelab reference, whether it failed or not
+	// postponed are codelabs changed by cancelled rebuilds, which browsers weren't notified of yet
+	postponed []codelab.Codelab
+
+	watcher fileWatcher
+	// muWatch protects watched triggers and directories, read from the event loop
+	muWatch  sync.RWMutex
+	triggers map[string]watchTrigger
+	dirs     []string
+}
+
+// newWorkspace creates an empty workspace, building codelabs with template and watching their files
+// with watcher.
+func newWorkspace(template string, watcher fileWatcher) *workspace {
+	return &workspace{
+		template: template,
+		watcher:  watcher,
+		errors:   make(map[string]websocket.BuildError),
+		builds:   make(map[string]buildTime),
+	}
+}
+
+// served returns a copy of served codelabs
+func (ws *workspace) served() []codelab.Codelab {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return append([]codelab.Codelab(nil), ws.codelabs...)
+}
+
+// failingCodelabs returns a copy of codelabs which never built successfully
+func (ws *workspace) failingCodelabs() []codelab.Codelab {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return append([]codelab.Codelab(nil), ws.failing...)
+}
+
+// known returns a copy of served and failing codelabs
+func (ws *workspace) known() []codelab.Codelab {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.all()
+}
+
+// all returns a copy of served and failing codelabs. mu must be held.
+func (ws *workspace) all() []codelab.Codelab {
+	return append(append([]codelab.Codelab(nil), ws.codelabs...), ws.failing...)
+}
+
+// update replaces served and failing codelabs.
+// Build times of codelabs which aren't served nor failing anymore are forgotten.
+func (ws *workspace) update(served, failing []codelab.Codelab) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.codelabs = served
+	ws.failing = failing
+
+	known := make(map[string]bool)
+	for _, c := range ws.all() {
+		known[c.RefURI] = true
+	}
+	for ref := range ws.builds {
+		if _, failed := ws.errors[ref]; !known[ref] && !failed {
+			delete(ws.builds, ref)
+		}
+	}
+}
+
+// postpone keeps changed codelabs of a cancelled rebuild for the next one to notify browsers of them
+func (ws *workspace) postpone(changed []codelab.Codelab) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.postponed = append(ws.postponed, changed...)
+}
+
+// takePostponed returns and forgets changed codelabs of cancelled rebuilds
+func (ws *workspace) takePostponed() []codelab.Codelab {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	changed := ws.postponed
+	ws.postponed = nil
+	return changed
+}
+
+// replace updates a served codelab after it was rebuilt
+func (ws *workspace) replace(c codelab.Codelab) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i := range ws.codelabs {
+		if ws.codelabs[i].RefURI == c.RefURI {
+			ws.codelabs[i] = c
+			return
+		}
+	}
+}
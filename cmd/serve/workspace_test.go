@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubuntu/tutorial-deployment/codelab"
+)
+
+func TestWorkspaceUpdate(t *testing.T) {
+	ws := newWorkspace("", nil)
+	a := codelab.Codelab{RefURI: "a.md"}
+	b := codelab.Codelab{RefURI: "b.md"}
+	ws.recordBuild("a.md", time.Now())
+	ws.recordBuild("b.md", time.Now())
+	ws.recordBuild("c.md", time.Now())
+	ws.recordBuild("d.md", time.Now())
+	ws.setBuildError("d.md", nil, errors.New("d failed"))
+	ws.update([]codelab.Codelab{a}, []codelab.Codelab{b})
+
+	served := ws.served()
+	served[0].RefURI = "changed.md"
+	if got := ws.served(); len(got) != 1 || got[0].RefURI != "a.md" {
+		t.Errorf("got %+v served; want a copy of a.md", got)
+	}
+	var refs []string
+	for _, c := range ws.known() {
+		refs = append(refs, c.RefURI)
+	}
+	if want := []string{"a.md", "b.md"}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("got %v known codelabs; want %v", refs, want)
+	}
+	// build times of removed codelabs without errors are forgotten
+	var built []string
+	for _, s := range ws.status().Codelabs {
+		if s.LastBuild != nil {
+			built = append(built, s.Ref)
+		}
+	}
+	if want := []string{"a.md", "b.md", "d.md"}; !reflect.DeepEqual(built, want) {
+		t.Errorf("got %v codelabs with a build time; want %v", built, want)
+	}
+
+	a.Title = "rebuilt"
+	ws.replace(a)
+	ws.replace(codelab.Codelab{RefURI: "unknown.md"})
+	if got := ws.served(); len(got) != 1 || got[0].Title != "rebuilt" {
+		t.Errorf("got %+v served; want a.md replaced", got)
+	}
+}
+
+// TestWorkspaceConcurrentAccess is mostly useful with -race: builds change the workspace while http
+// handlers and pollers read it.
+func TestWorkspaceConcurrentAccess(t *testing.T) {
+	ws := newWorkspace("", nil)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ref := fmt.Sprintf("%d-%d.md", i, j%5)
+				c := codelab.Codelab{RefURI: ref, FilesWatched: []string{ref}, RemoteFiles: []string{"http://example.com/" + ref}}
+				c.ID = ref
+				ws.recordBuild(ref, time.Now())
+				if j%2 == 0 {
+					ws.setBuildError(ref, &c, errors.New("failed"))
+					ws.update(nil, []codelab.Codelab{c})
+					continue
+				}
+				ws.clearBuildError(ref)
+				ws.update([]codelab.Codelab{c}, nil)
+				c.Title = "rebuilt"
+				ws.replace(c)
+			}
+		}(i)
+	}
+
+	readers := []func(){
+		func() { ws.status() },
+		func() { ws.remoteFiles(isRemoteFile) },
+		func() { ws.buildErrors() },
+		func() { ws.remoteRefs() },
+		func() { ws.codelabRefs("0-1.md") },
+		func() {
+			for _, c := range ws.known() {
+				c.FilesWatched = append(c.FilesWatched, "other")
+			}
+		},
+		func() { ws.serveStatusJSON(httptest.NewRecorder(), httptest.NewRequest("GET", statusJSONURL, nil)) },
+	}
+	var readersWg sync.WaitGroup
+	for _, read := range readers {
+		readersWg.Add(1)
+		go func(read func()) {
+			defer readersWg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					read()
+				}
+			}
+		}(read)
+	}
+
+	wg.Wait()
+	close(stop)
+	readersWg.Wait()
+}
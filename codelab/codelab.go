@@ -13,7 +13,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/didrocks/codelab-ubuntu-tools/claat/parser"
 	"github.com/didrocks/codelab-ubuntu-tools/claat/render"
@@ -30,103 +32,240 @@ const (
 	appspotPreviewURL = "https://codelabs-preview.appspot.com/?file_id="
 	relativeImgDir    = "img" // img relative directory in codelab
 	metaFilename      = "codelab.json"
+	indexFilename     = "index.html"
 )
 
+// Build stages reported in BuildError
+const (
+	FetchStage  = "fetch"  // fetching and parsing codelab source and imports
+	AssetsStage = "assets" // copying images and other assets
+	RenderStage = "render" // writing html and metadata
+)
+
+// outputs are all generated files and directories in a codelab directory.
+// Other language variants of the same codelab are stored in subdirectories.
+var outputs = []string{indexFilename, metaFilename, relativeImgDir}
+
+// languageRefRegexp matches language declared in markdown file names, like snap-basics.fr.md or snap-basics.pt_BR.md
+var languageRefRegexp = regexp.MustCompile(`\.([a-z]{2}(?:[-_][A-Z]{2})?)\.md$`)
+
+// gdocMetadataWarning only logs once that google docs can't declare our extra metadata
+var gdocMetadataWarning sync.Once
+
 // Codelab augments claat Codelab object by owning all Codelab Metadata and last updated time
 type Codelab struct {
 	RefURI string `json:"-"` // Reference uri path
 	types.Codelab
-	FilesWatched []string  `json:"-"`               // Path to asset files to watch
-	HideSteps    *struct{} `json:"Steps,omitempty"` // Hide the Steps json export from types.Codelab with this nil object
+	FilesWatched  []string  `json:"-"`                       // Path to asset files to watch
+	RemoteFiles   []string  `json:"-"`                       // Google docs and remote urls, which can't be watched
+	HideSteps     *struct{} `json:"Steps,omitempty"`         // Hide the Steps json export from types.Codelab with this nil object
+	Prerequisites []string  `json:"prerequisites,omitempty"` // Codelab IDs to follow before this one
+	Unlocks       []string  `json:"unlocks,omitempty"`       // Codelab IDs having this one as a prerequisite
+	Related       []string  `json:"related,omitempty"`       // Recommended codelab IDs to follow after this one
+	RelatedPins   []string  `json:"-"`                       // Codelab IDs always listed first in related ones
+	RelatedBans   []string  `json:"-"`                       // Codelab IDs never listed in related ones
+	Language      string    `json:"language,omitempty"`      // Language of this variant, empty for the main one
+	Languages     []string  `json:"languages,omitempty"`     // All available languages for this codelab
 
 	watch    bool   // We will need to watch files
 	dir      string // path where the codelab is stored
+	dest     string // export directory of all codelabs
 	template string // template path used
 }
 
+// BuildError is an error happening at a given stage of a codelab build
+type BuildError struct {
+	Stage string // one of the build stages
+	File  string // file or url which couldn't be processed
+	Err   error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%s failed on %s: %v", e.Stage, e.File, e.Err)
+}
+
+// newBuildError wraps err in a BuildError, unless it's already one
+func newBuildError(stage, file string, err error) error {
+	if _, ok := err.(*BuildError); ok {
+		return err
+	}
+	return &BuildError{Stage: stage, File: file, Err: err}
+}
+
 // New retrieves and parses codelab source.
+// On failure, the codelab is returned with what was built before the error, like its ID and files to watch
+// for a fix, along with the error. It can't be refreshed: it needs to be created again.
 func New(codelabRef, dest, template string, watch bool) (*Codelab, error) {
 	c := Codelab{
 		RefURI:   codelabRef,
+		dest:     dest,
 		template: template,
 		watch:    watch,
 	}
 	if err := c.download(); err != nil {
-		return nil, err
+		return &c, newBuildError(FetchStage, c.RefURI, err)
 	}
-	c.dir = filepath.Join(dest, c.ID)
-	if err := c.downloadAssets(); err != nil {
-		return nil, err
+	c.dir = filepath.Join(dest, c.ID, c.Language)
+	if err := c.downloadAssets(c.dir); err != nil {
+		return &c, newBuildError(AssetsStage, c.RefURI, err)
 	}
-	if err := c.writeCodelab(); err != nil {
-		return nil, err
+	if err := c.writeCodelab(c.dir); err != nil {
+		return &c, newBuildError(RenderStage, c.template, err)
 	}
 	return &c, nil
 }
 
-// Refresh content and assets of given codelab
+// Refresh content and assets of given codelab.
+// It's built in a staging directory next to the export one, which isn't served, then swapped with the current
+// output: the codelab is served without interruption and the last successful build is kept on failure.
+// Its output moves with its ID or language, the previous one being removed.
 func (c *Codelab) Refresh() error {
-	if err := c.wipe(); err != nil {
-		return err
+	if c.dir == "" {
+		return newBuildError(RenderStage, c.RefURI, errors.New("codelab was never built: it needs to be created again"))
+	}
+	staging, err := c.stagingDir()
+	if err != nil {
+		return newBuildError(RenderStage, c.dir, err)
 	}
+	defer os.RemoveAll(staging)
+
 	c.FilesWatched = nil
+	c.RemoteFiles = nil
 	if err := c.download(); err != nil {
+		return newBuildError(FetchStage, c.RefURI, err)
+	}
+	if err := c.downloadAssets(staging); err != nil {
+		return newBuildError(AssetsStage, c.RefURI, err)
+	}
+	if err := c.writeCodelab(staging); err != nil {
+		return newBuildError(RenderStage, c.template, err)
+	}
+	previous := c.dir
+	c.dir = filepath.Join(c.dest, c.ID, c.Language)
+	if err := c.swap(staging); err != nil {
+		return newBuildError(RenderStage, c.dir, err)
+	}
+	if c.dir != previous {
+		if err := removeOutputs(previous); err != nil {
+			return newBuildError(RenderStage, previous, err)
+		}
+	}
+	return nil
+}
+
+// Remove generated content of given codelab
+func (c *Codelab) Remove() error {
+	// nothing was written for codelabs failing before getting their ID
+	if c.dir == "" {
+		return nil
+	}
+	return removeOutputs(c.dir)
+}
+
+// removeOutputs removes generated content in dir, and dir itself if no other language variant is in it
+func removeOutputs(dir string) error {
+	if err := wipe(dir); err != nil {
 		return err
 	}
-	if err := c.downloadAssets(); err != nil {
+	fs, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	return c.writeCodelab()
+	if len(fs) > 0 {
+		return nil
+	}
+	return os.Remove(dir)
 }
 
 // download and parse codelab content
 // The function will also fetch, parse and integrate its imports
 func (c *Codelab) download() error {
+	// watch the source first, to be notified of fixes if it doesn't build
+	c.appendResourceToWatchFile(c.RefURI)
 	res, err := claattools.Fetch(c.RefURI)
 	if err != nil {
 		return fmt.Errorf("failed getting: %v", err)
 	}
 	defer res.Body.Close()
-	clab, err := parser.Parse(res.Type, res.Body)
+	src, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading: %v", err)
+	}
+	clab, err := parser.Parse(res.Type, bytes.NewReader(src))
 	if err != nil {
 		return err
 	}
+	meta := parseExtraMetadata(src)
+	if claattools.IsGdoc(c.RefURI) {
+		gdocMetadataWarning.Do(func() {
+			log.Printf("Google doc codelabs, like %s, can't declare prerequisites, related codelabs or language: "+
+				"only markdown codelabs can, in their header", c.RefURI)
+		})
+	}
 
 	// fetch imports and parse them as fragments
 	var imports []*types.ImportNode
 	for _, st := range clab.Steps {
 		imports = append(imports, claattools.GetImportNodes(st.Content.Nodes)...)
 	}
-	ch := make(chan error, len(imports))
-	defer close(ch)
+	// imports are only recorded to be watched from this goroutine. The channel is buffered for remaining
+	// imports to not block once we return on the first error.
+	type imported struct {
+		url string
+		err error
+	}
+	ch := make(chan imported, len(imports))
 	for _, imp := range imports {
 		go func(n *types.ImportNode) {
 			frag, err := getFragment(n.URL)
 			if err != nil {
-				ch <- fmt.Errorf("%s from import: %s", n.URL, err)
+				ch <- imported{n.URL, &BuildError{Stage: FetchStage, File: n.URL, Err: fmt.Errorf("from import: %s", err)}}
 				return
 			}
 			n.Content.Nodes = frag
-			c.appendResourceToWatchFile(n.URL)
-			ch <- nil
+			ch <- imported{n.URL, nil}
 		}(imp)
 	}
 	for _ = range imports {
-		if err := <-ch; err != nil {
-			return err
+		r := <-ch
+		if r.err != nil {
+			return r.err
 		}
+		c.appendResourceToWatchFile(r.url)
 	}
 
 	c.Codelab = *clab
-	c.appendResourceToWatchFile(c.RefURI)
+	c.Prerequisites = meta.list(prerequisitesKey)
+	c.RelatedPins = meta.list(relatedKey)
+	c.RelatedBans = meta.list(unrelatedKey)
+	c.Language = languageFromRef(c.RefURI, meta)
+	if c.Language != "" {
+		c.URL = path.Join(c.URL, c.Language)
+	}
 	return nil
 }
 
+// IsPublished returns true if the codelab has a published status
+func (c *Codelab) IsPublished() bool {
+	if c.Status == nil {
+		return false
+	}
+	for _, s := range *c.Status {
+		if strings.ToLower(s) == "published" {
+			return true
+		}
+	}
+	return false
+}
+
 var crcTable = crc64.MakeTable(crc64.ECMA)
 
-// downloadAssets get images and other assets associated to the codelab
-func (c *Codelab) downloadAssets() (err error) {
-	imgDir := path.Join(c.dir, relativeImgDir)
+// downloadAssets get images and other assets associated to the codelab, writing them in dir
+func (c *Codelab) downloadAssets(dir string) (err error) {
+	imgDir := path.Join(dir, relativeImgDir)
 	if err := os.MkdirAll(imgDir, 0755); err != nil {
 		return err
 	}
@@ -191,27 +330,31 @@ func (c *Codelab) downloadAssets() (err error) {
 		}
 	}
 
-	// fetch possible errors
+	// fetch possible errors, reporting the first failing asset
 	var errs bytes.Buffer
+	var failed string
 	for i := 0; i < nImages; i++ {
 		r := <-ch
+		// failing assets are watched too, to be notified once they are fixed
+		c.appendResourceToWatchFile(r.src)
 		if r.err != nil {
 			errs.WriteString(fmt.Sprintf("Couldn't copy %s => %s: %v\n", r.src, r.dest, r.err))
-			continue
+			if failed == "" {
+				failed = r.src
+			}
 		}
-		c.appendResourceToWatchFile(r.src)
 	}
 
 	if errs.Len() > 0 {
-		err = errors.New(errs.String())
+		err = &BuildError{Stage: AssetsStage, File: failed, Err: errors.New(errs.String())}
 	}
 	return err
 }
 
-// write codelab itself to disk: html content and json metadata
-func (c *Codelab) writeCodelab() error {
+// write codelab itself to disk, in dir: html content and json metadata
+func (c *Codelab) writeCodelab(dir string) error {
 	// make sure codelab dir exists
-	if err := os.MkdirAll(c.dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
@@ -220,12 +363,12 @@ func (c *Codelab) writeCodelab() error {
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(filepath.Join(c.dir, metaFilename), b, 0644); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(dir, metaFilename), b, 0644); err != nil {
 		return err
 	}
 
 	// main content file(s)
-	f, err := os.Create(filepath.Join(c.dir, "index.html"))
+	f, err := os.Create(filepath.Join(dir, indexFilename))
 	if err != nil {
 		return err
 	}
@@ -233,10 +376,77 @@ func (c *Codelab) writeCodelab() error {
 	return render.Execute(f, c.template, c)
 }
 
-// wipe output directory content for codelab
-// Used when autorefreshing
-func (c *Codelab) wipe() error {
-	return os.RemoveAll(c.dir)
+// stagingDir creates a directory to build the codelab in, outside of the export directory for its content not
+// to be served until it's complete. It's created next to it, to be on the same filesystem for outputs to be
+// moved from it atomically.
+func (c *Codelab) stagingDir() (string, error) {
+	return ioutil.TempDir(filepath.Dir(c.dest), "."+filepath.Base(c.dest)+"-")
+}
+
+// swap moves outputs built in staging to the codelab directory, replacing each file atomically.
+// Images are named after their content: new ones are added before the html referencing them replaces
+// the current one, and the ones it doesn't reference anymore are removed last. The html goes after the
+// metadata, once every other output is in place.
+func (c *Codelab) swap(staging string) error {
+	imgDir := filepath.Join(c.dir, relativeImgDir)
+	if err := os.MkdirAll(imgDir, 0755); err != nil {
+		return err
+	}
+	imgs, err := ioutil.ReadDir(filepath.Join(staging, relativeImgDir))
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, f := range imgs {
+		used[f.Name()] = true
+		if err := os.Rename(filepath.Join(staging, relativeImgDir, f.Name()), filepath.Join(imgDir, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range []string{metaFilename, indexFilename} {
+		if err := os.Rename(filepath.Join(staging, o), filepath.Join(c.dir, o)); err != nil {
+			return err
+		}
+	}
+
+	current, err := ioutil.ReadDir(imgDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range current {
+		if used[f.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(imgDir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipe output directory content for codelab, preserving other language variants
+// Used when removing a codelab
+func wipe(dir string) error {
+	for _, o := range outputs {
+		if err := os.RemoveAll(filepath.Join(dir, o)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// languageFromRef returns the codelab language, declared in its metadata or in its file name.
+func languageFromRef(ref string, meta extraMetadata) string {
+	for _, k := range []string{languageKey, langKey} {
+		if l := meta[k]; l != "" {
+			return l
+		}
+	}
+	if m := languageRefRegexp.FindStringSubmatch(path.Base(ref)); m != nil {
+		return m[1]
+	}
+	return ""
 }
 
 func (c *Codelab) appendResourceToWatchFile(refPath string) error {
@@ -251,25 +461,31 @@ func (c *Codelab) appendResourceToWatchFile(refPath string) error {
 
 	if u.Host == "" && strings.HasPrefix(refPath, consts.GdocPrefix) {
 		gdocID := strings.TrimPrefix(refPath, consts.GdocPrefix)
-		log.Printf("Can't track %s for changes as it refers to a google doc. You can head over "+
-			"to %s%s to preview it with a default template dynamically.", gdocID, appspotPreviewURL, gdocID)
+		log.Printf("Can't watch %s for changes as it refers to a google doc: its modification time needs to be "+
+			"polled. You can also head over to %s%s to preview it with a default template dynamically.",
+			gdocID, appspotPreviewURL, gdocID)
+		c.RemoteFiles = appendUnique(c.RemoteFiles, refPath)
 		return nil
 	}
 
 	if u.Host != "" {
-		log.Printf("%s: Can't track %s for changes as it's a remote resource. You will need to rerun this binary and "+
-			"refresh your page to preview the changes", c.RefURI, refPath)
+		log.Printf("%s: Can't watch %s for changes as it's a remote resource: it needs to be polled.", c.RefURI, refPath)
+		c.RemoteFiles = appendUnique(c.RemoteFiles, refPath)
 		return nil
 	}
 
-	// don't add duplicates
-	for _, f := range c.FilesWatched {
-		if f == refPath {
-			return nil
+	c.FilesWatched = appendUnique(c.FilesWatched, refPath)
+	return nil
+}
+
+// appendUnique appends s to l if it's not already in it
+func appendUnique(l []string, s string) []string {
+	for _, e := range l {
+		if e == s {
+			return l
 		}
 	}
-	c.FilesWatched = append(c.FilesWatched, refPath)
-	return nil
+	return append(l, s)
 }
 
 func getFragment(url string) ([]types.Node, error) {
@@ -153,6 +153,255 @@ func TestRefreshCodelabs(t *testing.T) {
 	}
 }
 
+func TestRefreshKeepsLastBuild(t *testing.T) {
+	var generatedpath = "testdata/codelabgenerated"
+	out, teardown := tempDir(t)
+	defer teardown()
+
+	c, err := New("testdata/codelabsrc/markdown-with-images-simple.md", out, "testdata/template.html", false)
+	if err != nil {
+		t.Fatalf("Couldn't create codelab: %v", err)
+	}
+
+	// a failing refresh keeps the last successful build
+	c.RefURI = "testdata/codelabsrc/markdown-missing-image.md"
+	if err := c.Refresh(); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	compareAll(t, path.Join(generatedpath, "markdown-with-images-simple.md"), out, nil)
+	assertDirContent(t, out, []string{"example-snap-tutorial"})
+
+	// a successful one replaces it, without images it doesn't use anymore
+	c.RefURI = "testdata/codelabsrc/markdown-no-image.md"
+	if err := c.Refresh(); err != nil {
+		t.Fatalf("Refresh() unexpected error: %v", err)
+	}
+	compareAll(t, path.Join(generatedpath, "markdown-no-image.md"), out, nil)
+	assertDirContent(t, out, []string{"example-snap-tutorial"})
+	assertDirContent(t, filepath.Join(out, "example-snap-tutorial", relativeImgDir), nil)
+}
+
+func TestRefreshMovesWithLanguage(t *testing.T) {
+	root, teardown := tempDir(t)
+	defer teardown()
+	src := filepath.Join(root, "a.md")
+	template := filepath.Join(root, "template.html")
+	out := filepath.Join(root, "codelabs")
+	writeSource := func(header string) {
+		if err := ioutil.WriteFile(src, []byte("---\nid: a\n"+header+"\n---\n\n# Codelab a\n\n## Step\n"), 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(template, []byte("{{.Title}}"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	writeSource("")
+	c, err := New(src, out, template, false)
+	if err != nil {
+		t.Fatalf("Couldn't create codelab: %v", err)
+	}
+
+	testCases := []struct {
+		header string
+
+		wantURL   string
+		wantFiles []string // in the codelab directory
+	}{
+		{"lang: fr\n", "a/fr", []string{"fr"}},
+		{"lang: pt_BR\n", "a/pt_BR", []string{"pt_BR"}},
+		{"", "a", []string{metaFilename, relativeImgDir, indexFilename}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("refresh with header %q", tc.header), func(t *testing.T) {
+			writeSource(tc.header)
+			if err := c.Refresh(); err != nil {
+				t.Fatalf("Refresh() unexpected error: %v", err)
+			}
+
+			if c.URL != tc.wantURL {
+				t.Errorf("got url %q; want %q", c.URL, tc.wantURL)
+			}
+			if _, err := os.Stat(filepath.Join(out, filepath.FromSlash(tc.wantURL), indexFilename)); err != nil {
+				t.Errorf("%s isn't served: %v", tc.wantURL, err)
+			}
+			// previous outputs are removed
+			assertDirContent(t, out, []string{"a"})
+			assertDirContent(t, filepath.Join(out, "a"), tc.wantFiles)
+		})
+	}
+}
+
+func TestStagingDir(t *testing.T) {
+	root, teardown := tempDir(t)
+	defer teardown()
+	dest := filepath.Join(root, "codelabs")
+	c := Codelab{dir: filepath.Join(dest, "foo", "fr"), dest: dest}
+
+	staging, err := c.stagingDir()
+	if err != nil {
+		t.Fatalf("stagingDir() unexpected error: %v", err)
+	}
+	if filepath.Dir(staging) != root {
+		t.Errorf("got staging directory %s; want it next to the export directory %s, not served", staging, dest)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	testCases := []struct {
+		src string
+
+		wantStage string
+		wantFile  string
+		wantID    string
+
+		wantOtherFilesWatched []string
+	}{
+		{"testdata/codelabsrc/doesnt-exist.md", FetchStage, "testdata/codelabsrc/doesnt-exist.md", "", []string{}},
+		{"testdata/codelabsrc/markdown-missing-image.md", AssetsStage, "testdata/codelabsrc/unexisting.png", "example-snap-tutorial",
+			[]string{"testdata/codelabsrc/foo.png", "testdata/codelabsrc/unexisting.png"}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("build error for %s", tc.src), func(t *testing.T) {
+			out, teardown := tempDir(t)
+			defer teardown()
+
+			c, err := New(tc.src, out, "testdata/template.html", true)
+
+			e, ok := err.(*BuildError)
+			if !ok {
+				t.Fatalf("expected a build error; got %v", err)
+			}
+			if e.Stage != tc.wantStage {
+				t.Errorf("got stage %q; want %q", e.Stage, tc.wantStage)
+			}
+			if e.File != tc.wantFile {
+				t.Errorf("got file %q; want %q", e.File, tc.wantFile)
+			}
+
+			// what was built is returned, with the source to watch for a fix
+			if c == nil {
+				t.Fatal("expected the failing codelab to be returned")
+			}
+			if c.ID != tc.wantID {
+				t.Errorf("got ID %q; want %q", c.ID, tc.wantID)
+			}
+			if len(c.FilesWatched) == 0 || c.FilesWatched[0] != tc.src {
+				t.Errorf("got files watched %+v; want %s to be watched first", c.FilesWatched, tc.src)
+			}
+			others := append([]string{}, c.FilesWatched[1:]...)
+			sort.Strings(others)
+			if !reflect.DeepEqual(others, tc.wantOtherFilesWatched) {
+				t.Errorf("got files watched %+v; want %s then %+v", c.FilesWatched, tc.src, tc.wantOtherFilesWatched)
+			}
+		})
+	}
+}
+
+func TestLanguageFromRef(t *testing.T) {
+	testCases := []struct {
+		ref  string
+		meta extraMetadata
+
+		wantLanguage string
+	}{
+		{"snap-basics.md", nil, ""},
+		{"snap-basics.fr.md", nil, "fr"},
+		{"foo/snap-basics.pt_BR.md", nil, "pt_BR"},
+		{"foo/snap-basics.pt-BR.md", nil, "pt-BR"},
+		{"foo.fr/snap-basics.md", nil, ""},
+		{"snap-basics.v2.md", nil, ""},
+		{"snap-basics.md", extraMetadata{"language": "de"}, "de"},
+		{"snap-basics.md", extraMetadata{"lang": "de"}, "de"},
+		{"snap-basics.fr.md", extraMetadata{"lang": "de"}, "de"}, // metadata wins
+		{fmt.Sprintf("%s1XUIwNcJj0IIFtza-py5BGDUlWoNeXyO2V0XgNOQvyDQ", consts.GdocPrefix), nil, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("language of %s, with %+v", tc.ref, tc.meta), func(t *testing.T) {
+			if l := languageFromRef(tc.ref, tc.meta); l != tc.wantLanguage {
+				t.Errorf("got %q; want %q", l, tc.wantLanguage)
+			}
+		})
+	}
+}
+
+func TestResourcesTracking(t *testing.T) {
+	testCases := []struct {
+		resources []string
+		watch     bool
+
+		wantFilesWatched []string
+		wantRemoteFiles  []string
+	}{
+		{[]string{"foo.md", "img/foo.png"}, true, []string{"foo.md", "img/foo.png"}, nil},
+		{[]string{"foo.md", "foo.md"}, true, []string{"foo.md"}, nil},
+		{[]string{"foo.md", "https://example.com/foo.png"}, true, []string{"foo.md"}, []string{"https://example.com/foo.png"}},
+		{[]string{consts.GdocPrefix + "doc", consts.GdocPrefix + "doc"}, true, nil, []string{consts.GdocPrefix + "doc"}},
+		{[]string{"foo.md", "https://example.com/foo.png"}, false, nil, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("track %v, watch: %v", tc.resources, tc.watch), func(t *testing.T) {
+			c := Codelab{watch: tc.watch}
+			for _, r := range tc.resources {
+				if err := c.appendResourceToWatchFile(r); err != nil {
+					t.Fatalf("appendResourceToWatchFile() unexpected error: %v", err)
+				}
+			}
+
+			if !reflect.DeepEqual(c.FilesWatched, tc.wantFilesWatched) {
+				t.Errorf("got files watched %+v; want %+v", c.FilesWatched, tc.wantFilesWatched)
+			}
+			if !reflect.DeepEqual(c.RemoteFiles, tc.wantRemoteFiles) {
+				t.Errorf("got remote files %+v; want %+v", c.RemoteFiles, tc.wantRemoteFiles)
+			}
+		})
+	}
+}
+
+func TestWipePreservesOtherVariants(t *testing.T) {
+	out, teardown := tempDir(t)
+	defer teardown()
+
+	for _, f := range []string{"index.html", "codelab.json", "img/foo.png", "fr/index.html", "fr/img/foo.png"} {
+		p := filepath.Join(out, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte("content"), 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := wipe(out); err != nil {
+		t.Fatalf("wipe() unexpected error: %v", err)
+	}
+
+	for _, f := range []string{"index.html", "codelab.json", "img"} {
+		if _, err := os.Stat(filepath.Join(out, f)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", f)
+		}
+	}
+	for _, f := range []string{"fr/index.html", "fr/img/foo.png"} {
+		if _, err := os.Stat(filepath.Join(out, f)); err != nil {
+			t.Errorf("%s should have been kept: %v", f, err)
+		}
+	}
+}
+
+// assertDirContent checks dir only contains want files and directories, with no leftover from builds
+func assertDirContent(t *testing.T, dir string, want []string) {
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Couldn't read %s: %v", dir, err)
+	}
+	var got []string
+	for _, f := range fs {
+		got = append(got, f.Name())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in %s; want %v", got, dir, want)
+	}
+}
+
 func tempDir(t *testing.T) (string, func()) {
 	path, err := ioutil.TempDir("", "tutorial-test")
 	if err != nil {
@@ -0,0 +1,61 @@
+package codelab
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// metadata keys handled by us, on top of the ones claat is parsing
+const (
+	prerequisitesKey = "prerequisites"
+	relatedKey       = "related"
+	unrelatedKey     = "unrelated"
+	languageKey      = "language"
+	langKey          = "lang"
+)
+
+// extraMetadata are metadata key/values from the codelab source header
+type extraMetadata map[string]string
+
+// parseExtraMetadata reads the header of a markdown codelab, delimited by "---" lines.
+// Sources without any header (like google docs) return empty metadata: google docs can't declare them.
+func parseExtraMetadata(src []byte) extraMetadata {
+	m := make(extraMetadata)
+	s := bufio.NewScanner(bytes.NewReader(src))
+	inHeader := false
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "---" {
+			if inHeader {
+				break
+			}
+			inHeader = true
+			continue
+		}
+		if !inHeader {
+			// the header is necessarily the first element in the file
+			if l == "" {
+				continue
+			}
+			break
+		}
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		m[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return m
+}
+
+// list returns comma separated values for key, without empty elements
+func (m extraMetadata) list(key string) []string {
+	var r []string
+	for _, v := range strings.Split(m[key], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
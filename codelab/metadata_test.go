@@ -0,0 +1,84 @@
+package codelab
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/didrocks/codelab-ubuntu-tools/claat/types"
+)
+
+func TestParseExtraMetadata(t *testing.T) {
+	testCases := []struct {
+		src string
+
+		wantMetadata extraMetadata
+	}{
+		{"---\nid: foo\nprerequisites: bar, baz\n---\n# Title\n", extraMetadata{"id": "foo", "prerequisites": "bar, baz"}},
+		{"\n\n---\nid: foo\n---\n", extraMetadata{"id": "foo"}},                                                        // empty lines before header
+		{"---\nId:foo\nfeedback link: http://Link\n---\n", extraMetadata{"id": "foo", "feedback link": "http://Link"}}, // url values aren't cut
+		{"---\nid: foo\n---\nprerequisites: bar\n", extraMetadata{"id": "foo"}},                                        // only header is parsed
+		{"---\nid: foo\nnotakeyvalue\n---\n", extraMetadata{"id": "foo"}},
+		{"# Title\n---\nid: foo\n---\n", extraMetadata{}}, // header needs to be first
+		{"<html><body>some gdoc</body></html>", extraMetadata{}},
+		{"", extraMetadata{}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("parse metadata of %q", tc.src), func(t *testing.T) {
+			m := parseExtraMetadata([]byte(tc.src))
+
+			if !reflect.DeepEqual(m, tc.wantMetadata) {
+				t.Errorf("got %+v; want %+v", m, tc.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestExtraMetadataList(t *testing.T) {
+	testCases := []struct {
+		value string
+
+		wantList []string
+	}{
+		{"foo", []string{"foo"}},
+		{"foo,bar", []string{"foo", "bar"}},
+		{" foo , bar ,, baz", []string{"foo", "bar", "baz"}},
+		{"", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("list of %q", tc.value), func(t *testing.T) {
+			m := extraMetadata{"key": tc.value}
+
+			if l := m.list("key"); !reflect.DeepEqual(l, tc.wantList) {
+				t.Errorf("got %+v; want %+v", l, tc.wantList)
+			}
+		})
+	}
+}
+
+func TestIsPublished(t *testing.T) {
+	testCases := []struct {
+		status []string
+
+		want bool
+	}{
+		{[]string{"Published"}, true},
+		{[]string{"published"}, true},
+		{[]string{"draft", "published"}, true},
+		{[]string{"draft"}, false},
+		{nil, false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("status %+v", tc.status), func(t *testing.T) {
+			c := Codelab{}
+			if tc.status != nil {
+				s := types.LegacyStatus(tc.status)
+				c.Status = &s
+			}
+
+			if got := c.IsPublished(); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
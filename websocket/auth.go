@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TokenCookie is the cookie in which browsers can keep the shared token, sent on handshakes
+const TokenCookie = "serve-token"
+
+// SetAllowedOrigins sets the origins, besides the served host, from which browsers can connect.
+// Entries are full origins (like https://example.com:8080), hosts matching any scheme (like example.com)
+// or "*" for any origin. Requests without any origin, which don't come from browsers, are always allowed.
+// It should be called before serving any client.
+func (h *Hub) SetAllowedOrigins(origins []string) {
+	h.allowedOrigins = origins
+}
+
+// SetToken sets the shared token clients need to connect and send messages. An empty token disables it.
+// It should be called before serving any client.
+func (h *Hub) SetToken(token string) {
+	h.token = token
+}
+
+// Authorized returns true if the request has the shared token, if any, in its token parameter,
+// as a bearer Authorization header or in the TokenCookie.
+func (h *Hub) Authorized(r *http.Request) bool {
+	if h.token == "" {
+		return true
+	}
+	if h.validToken(r.URL.Query().Get("token")) {
+		return true
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && h.validToken(strings.TrimPrefix(auth, "Bearer ")) {
+		return true
+	}
+	if c, err := r.Cookie(TokenCookie); err == nil && h.validToken(c.Value) {
+		return true
+	}
+	return false
+}
+
+// validToken returns true if the token is the shared one, or if none is required
+func (h *Hub) validToken(token string) bool {
+	if h.token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// checkOrigin returns true if the request comes from the served host or any allowed origin
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range h.allowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) || strings.EqualFold(o, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept checks the origin and token of a client connection or request, replying with an error if it's refused
+func (h *Hub) Accept(w http.ResponseWriter, r *http.Request) bool {
+	if !h.checkOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return false
+	}
+	if !h.Authorized(r) {
+		http.Error(w, "Invalid or missing token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	testCases := []struct {
+		origin  string
+		allowed []string
+
+		want bool
+	}{
+		{"", nil, true},
+		{"http://localhost:8080", nil, true},
+		{"http://LOCALHOST:8080", nil, true},
+		{"http://example.com", nil, false},
+		{"http://example.com", []string{"http://example.com"}, true},
+		{"http://example.com", []string{"http://example.com/"}, true},
+		{"https://example.com", []string{"http://example.com"}, false},
+		{"https://example.com", []string{"example.com"}, true},
+		{"https://example.com:8443", []string{"example.com"}, false},
+		{"http://example.com", []string{"other.com", "example.com"}, true},
+		{"http://example.com", []string{"*"}, true},
+		{"://invalid", []string{"example.com"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("origin %q with %v allowed", tc.origin, tc.allowed), func(t *testing.T) {
+			h := NewHub()
+			h.SetAllowedOrigins(tc.allowed)
+			r := httptest.NewRequest("GET", "http://localhost:8080/reload", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+
+			if got := h.checkOrigin(r); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	testCases := []struct {
+		name   string
+		token  string
+		query  string
+		header string
+		cookie string
+
+		want bool
+	}{
+		{"no token required", "", "", "", "", true},
+		{"missing token", "secret", "", "", "", false},
+		{"token parameter", "secret", "secret", "", "", true},
+		{"invalid token parameter", "secret", "other", "", "", false},
+		{"bearer header", "secret", "", "Bearer secret", "", true},
+		{"invalid header", "secret", "", "Basic secret", "", false},
+		{"cookie", "secret", "", "", "secret", true},
+		{"invalid cookie", "secret", "", "", "secre", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHub()
+			h.SetToken(tc.token)
+			r := httptest.NewRequest("GET", "/reload?token="+tc.query, nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: TokenCookie, Value: tc.cookie})
+			}
+
+			if got := h.Authorized(r); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	testCases := []struct {
+		origin string
+		token  string
+
+		wantStatus int
+	}{
+		{"", "secret", http.StatusSwitchingProtocols},
+		{"http://example.com", "secret", http.StatusSwitchingProtocols},
+		{"", "", http.StatusUnauthorized},
+		{"", "other", http.StatusUnauthorized},
+		{"http://other.com", "secret", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("connect from %q with token %q", tc.origin, tc.token), func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			h.SetAllowedOrigins([]string{"example.com"})
+			h.SetToken("secret")
+			ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+			defer ts.Close()
+
+			header := http.Header{}
+			if tc.origin != "" {
+				header.Set("Origin", tc.origin)
+			}
+			c, res, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1)+"?token="+tc.token, header)
+			if err == nil {
+				defer c.Close()
+			}
+			if res == nil {
+				t.Fatalf("Couldn't connect: %v", err)
+			}
+			if res.StatusCode != tc.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestControlMessagesToken(t *testing.T) {
+	testCases := []struct {
+		token string
+
+		wantRefused bool
+	}{
+		{"secret", false},
+		{"", true},
+		{"other", true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("join with token %q", tc.token), func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			h.SetToken("secret")
+			h.SetPresenterCode("presenter code")
+			ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+			defer ts.Close()
+			c, cleanup := addClient(t, ts.URL+"?token=secret", h)
+			defer cleanup()
+
+			m := NewMessage(JoinMessage)
+			m.Room, m.Code, m.PresenterCode, m.Role, m.Token = "room", "code", "presenter code", PresenterRole, tc.token
+			if err := c.WriteJSON(m); err != nil {
+				t.Fatalf("Couldn't join: %v", err)
+			}
+
+			want := JoinedMessage
+			if tc.wantRefused {
+				want = ErrorMessage
+			}
+			wantMessage(t, c, want)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"time"
+)
+
+// ProtocolVersion is the version of json messages sent to clients. It's increased on any incompatible change.
+const ProtocolVersion = 1
+
+// Message types
+const (
+	// ReloadMessage is sent when codelab content changed and pages need to be reloaded
+	ReloadMessage = "reload"
+	// APIUpdatedMessage is sent when the API (codelab list, categories, events…) was regenerated
+	APIUpdatedMessage = "api-updated"
+	// AssetUpdatedMessage is sent when only website assets (like event images) changed
+	AssetUpdatedMessage = "asset-updated"
+	// BuildStartedMessage is sent before rebuilding codelabs
+	BuildStartedMessage = "build-started"
+	// BuildFailedMessage is sent when a build failed, listing all current errors
+	BuildFailedMessage = "build-failed"
+	// BuildSucceededMessage is sent when a build succeeded, listing remaining errors of other builds if any
+	BuildSucceededMessage = "build-succeeded"
+
+	// SubscribeMessage is sent by clients to only receive messages about its codelab IDs (or AllTopics)
+	SubscribeMessage = "subscribe"
+	// UnsubscribeMessage is sent by clients to stop receiving messages about its codelab IDs
+	UnsubscribeMessage = "unsubscribe"
+
+	// JoinMessage is sent by clients to join a presenter room, with its code and their role. Presenters send
+	// the presenter code of the server too
+	JoinMessage = "join"
+	// JoinedMessage is sent to clients which joined a room
+	JoinedMessage = "joined"
+	// LeaveMessage is sent by clients to leave their room
+	LeaveMessage = "leave"
+	// NavigateMessage is sent by presenters with their current position, and relayed to the room
+	NavigateMessage = "navigate"
+	// ErrorMessage is sent to clients when their request was refused
+	ErrorMessage = "error"
+
+	// ResumeMessage is sent by reconnecting clients with the last instance and sequence number they saw
+	ResumeMessage = "resume"
+	// SyncedMessage is sent to resuming clients once they got every message they missed
+	SyncedMessage = "synced"
+	// FullReloadMessage is sent to resuming clients which missed too many messages, or from another server instance
+	FullReloadMessage = "full-reload"
+)
+
+// Roles in a presenter room
+const (
+	PresenterRole = "presenter"
+	FollowerRole  = "follower"
+)
+
+// Message is sent in json to clients, and received from them for subscriptions, presenter mode and resuming
+type Message struct {
+	Version  int          `json:"version"`
+	Type     string       `json:"type"`
+	Time     time.Time    `json:"time"`
+	Instance string       `json:"instance,omitempty"` // server instance numbering messages
+	Seq      uint64       `json:"seq,omitempty"`      // message number in this instance
+	Codelabs []string     `json:"codelabs,omitempty"` // IDs of impacted codelabs
+	URLs     []string     `json:"urls,omitempty"`     // URLs of impacted codelabs
+	Errors   []BuildError `json:"errors,omitempty"`
+
+	// presenter mode
+	Room          string    `json:"room,omitempty"`
+	Code          string    `json:"code,omitempty"`
+	PresenterCode string    `json:"presenterCode,omitempty"` // only known by presenters, required to create rooms and navigate
+	Role          string    `json:"role,omitempty"`
+	Position      *Position `json:"position,omitempty"`
+
+	Reason string `json:"reason,omitempty"` // why a request was refused
+
+	Token string `json:"token,omitempty"` // shared token, required in client messages if set on the hub
+}
+
+// Position is a step in a codelab, shared by presenters with their room
+type Position struct {
+	Codelab string `json:"codelab"`
+	Step    int    `json:"step"`
+}
+
+// BuildError describes a failed codelab or API build
+type BuildError struct {
+	Codelab string `json:"codelab"` // codelab ID, or its source if the codelab couldn't be parsed
+	File    string `json:"file"`
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+}
+
+// NewMessage returns a message of the given type, timestamped now, for codelab IDs
+func NewMessage(msgType string, codelabs ...string) Message {
+	return Message{
+		Version:  ProtocolVersion,
+		Type:     msgType,
+		Time:     time.Now().UTC(),
+		Codelabs: codelabs,
+	}
+}
+
+// Publish sends a message in json to clients subscribed to its codelabs.
+// Messages without codelabs are sent to all connected clients.
+// Messages are numbered and kept for clients to resume after a disconnection.
+// Messages published once the hub is stopped are dropped.
+func (h *Hub) Publish(m Message) {
+	select {
+	case h.broadcast <- envelope{topics: m.Codelabs, msg: &m}:
+	case <-h.stop:
+	}
+}
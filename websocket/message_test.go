@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPublishMessage(t *testing.T) {
+	h, cleanup := createHub()
+	defer cleanup()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+	defer ts.Close()
+	c, cleanupClient := addClient(t, ts.URL, h)
+	defer cleanupClient()
+
+	before := time.Now().Add(-time.Second)
+	m := NewMessage(ReloadMessage, "codelab-a", "codelab-b")
+	m.URLs = []string{"/tutorial/codelab-a", "/tutorial/codelab-b"}
+	h.Publish(m)
+
+	var rcv Message
+	if err := c.ReadJSON(&rcv); err != nil {
+		t.Fatalf("Unexpected received message error: %v", err)
+	}
+	if rcv.Version != ProtocolVersion {
+		t.Errorf("got protocol version %d; want %d", rcv.Version, ProtocolVersion)
+	}
+	if rcv.Time.Before(before) || rcv.Time.After(time.Now()) {
+		t.Errorf("message time %v isn't current", rcv.Time)
+	}
+	if rcv.Seq != 1 || rcv.Instance != h.instance {
+		t.Errorf("got message %d of instance %q; want message 1 of %q", rcv.Seq, rcv.Instance, h.instance)
+	}
+	rcv.Time, rcv.Seq, rcv.Instance = m.Time, 0, ""
+	if !reflect.DeepEqual(rcv, m) {
+		t.Errorf("got %+v; want %+v", rcv, m)
+	}
+}
+
+func TestPublishMessageToSubscribers(t *testing.T) {
+	h, cleanup := createHub()
+	defer cleanup()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+	defer ts.Close()
+	c, cleanupClient := addClient(t, ts.URL, h)
+	defer cleanupClient()
+	if err := c.WriteJSON(NewMessage(SubscribeMessage, "codelab-b")); err != nil {
+		t.Fatalf("Couldn't subscribe: %v", err)
+	}
+	// wait for subscription to proceed
+	<-time.After(10 * time.Millisecond)
+
+	for _, m := range []Message{NewMessage(ReloadMessage, "codelab-a"), NewMessage(ReloadMessage, "codelab-b")} {
+		h.Publish(m)
+	}
+
+	var rcv Message
+	if err := c.ReadJSON(&rcv); err != nil {
+		t.Fatalf("Unexpected received message error: %v", err)
+	}
+	if !reflect.DeepEqual(rcv.Codelabs, []string{"codelab-b"}) {
+		t.Errorf("received message for %+v; want only messages for codelab-b", rcv.Codelabs)
+	}
+}
+
+func TestPublishAfterStop(t *testing.T) {
+	h, stopHub := createHub()
+	stopHub()
+
+	done := make(chan struct{})
+	go func() {
+		h.Publish(NewMessage(ReloadMessage, "codelab"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() blocked on a stopped hub")
+	}
+}
+
+func TestMessageOmitsEmptyFields(t *testing.T) {
+	b, err := json.Marshal(NewMessage(APIUpdatedMessage))
+	if err != nil {
+		t.Fatalf("Couldn't encode message: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatalf("Couldn't decode message: %v", err)
+	}
+	for _, k := range []string{"codelabs", "urls", "errors"} {
+		if _, ok := fields[k]; ok {
+			t.Errorf("%s shouldn't be part of %s", k, b)
+		}
+	}
+	for _, k := range []string{"version", "type", "time"} {
+		if _, ok := fields[k]; !ok {
+			t.Errorf("%s should be part of %s", k, b)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseHeartbeatPeriod is the time between two comments sent to keep server-sent events connections open
+// through proxies
+var sseHeartbeatPeriod = 15 * time.Second
+
+// NewSSEClient creates a new client connected to our hub, receiving messages as server-sent events.
+// This is a fallback for browsers which can't open a websocket, like behind some proxies.
+// Subscriptions are given with a codelabs parameter (comma-separated IDs, or "all"), and a reconnecting
+// client resumes from its Last-Event-ID header, or lastEventId parameter when it can't set headers.
+// As for websockets, connections from other origins than allowed ones or without the shared token are refused.
+func (hub *Hub) NewSSEClient(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Server-sent events aren't supported", http.StatusInternalServerError)
+		return
+	}
+	if !hub.Accept(w, r) {
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		// let allowed cross-origin pages read the events
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Vary", "Origin")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// disable proxy buffering (nginx)
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	topics := map[string]bool{AllTopics: true}
+	if codelabs := r.URL.Query().Get("codelabs"); codelabs != "" {
+		topics = make(map[string]bool)
+		for _, t := range strings.Split(codelabs, ",") {
+			topics[strings.TrimSpace(t)] = true
+		}
+	}
+	c := &client{hub: hub, queue: newQueue(hub.bufferSize), topics: topics}
+	select {
+	case hub.register <- c:
+	case <-hub.stop:
+		return
+	}
+	defer func() {
+		select {
+		case hub.unregister <- c:
+		case <-hub.stop:
+		}
+	}()
+
+	// tell the client where it is, or what it missed
+	resume := NewMessage(ResumeMessage)
+	resume.Token = hub.token
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	resume.Instance, resume.Seq = parseEventID(lastEventID)
+	select {
+	case hub.requests <- request{client: c, msg: resume}:
+	case <-hub.stop:
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.queue.ready:
+			for {
+				data, closed := c.queue.pop()
+				if closed {
+					// the hub removed the client, tell it why
+					_, reason := c.queue.closeReason()
+					fmt.Fprintf(w, ": closed: %s\n\n", reason)
+					flusher.Flush()
+					return
+				}
+				if data == nil {
+					break
+				}
+				if _, err := w.Write(sseEvent(data)); err != nil {
+					return
+				}
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// sseEvent formats a message as a server-sent event. Messages with a sequence number have it as their ID.
+func sseEvent(data []byte) []byte {
+	var b bytes.Buffer
+	var m struct {
+		Instance string
+		Seq      uint64
+	}
+	if json.Unmarshal(data, &m) == nil && m.Instance != "" {
+		fmt.Fprintf(&b, "id: %s:%d\n", m.Instance, m.Seq)
+	}
+	for _, l := range bytes.Split(data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(l)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// parseEventID returns the instance and sequence number of a server-sent event ID.
+// Unknown IDs are returning an empty instance.
+func parseEventID(id string) (string, uint64) {
+	i := strings.LastIndex(id, ":")
+	if i < 0 {
+		return "", 0
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", 0
+	}
+	return id[:i], seq
+}
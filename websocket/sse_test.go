@@ -0,0 +1,245 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEMessages(t *testing.T) {
+	h, cleanup := createHub()
+	defer cleanup()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewSSEClient))
+	defer ts.Close()
+
+	events, closeClient := connectSSE(t, ts.URL, "")
+	defer closeClient()
+
+	// the client is told where it starts from
+	e := wantEvent(t, events)
+	if want := fmt.Sprintf("%s:0", h.instance); e.id != want {
+		t.Errorf("got event id %q; want %q", e.id, want)
+	}
+	if m := e.message(t); m.Type != SyncedMessage {
+		t.Errorf("got %s message; want %s", m.Type, SyncedMessage)
+	}
+
+	h.Publish(NewMessage(ReloadMessage, "codelab-a"))
+	e = wantEvent(t, events)
+	if want := fmt.Sprintf("%s:1", h.instance); e.id != want {
+		t.Errorf("got event id %q; want %q", e.id, want)
+	}
+	if m := e.message(t); m.Type != ReloadMessage || !reflect.DeepEqual(m.Codelabs, []string{"codelab-a"}) {
+		t.Errorf("got %+v; want a reload message for codelab-a", m)
+	}
+
+	// raw messages are sent without id
+	h.Send([]byte("legacy"))
+	e = wantEvent(t, events)
+	if e.id != "" || e.data != "legacy" {
+		t.Errorf("got event %+v; want legacy data without id", e)
+	}
+}
+
+func TestSSEResume(t *testing.T) {
+	testCases := []struct {
+		lastEventID string
+		asParameter bool
+		codelabs    string
+
+		wantReplay []uint64
+		wantType   string
+	}{
+		{"", false, "", nil, SyncedMessage},
+		{"current:1", false, "", []uint64{2, 3}, SyncedMessage},
+		{"current:3", false, "", nil, SyncedMessage},
+		{"current:0", false, "b", []uint64{2}, SyncedMessage},
+		{"previous:1", false, "", nil, FullReloadMessage},
+		{"invalid", false, "", nil, SyncedMessage},
+		{"current:1", true, "", []uint64{2, 3}, SyncedMessage},
+		{"current:0", true, "b", []uint64{2}, SyncedMessage},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("resume from %q (parameter: %v) for %q", tc.lastEventID, tc.asParameter, tc.codelabs), func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			ts := httptest.NewServer(http.HandlerFunc(h.NewSSEClient))
+			defer ts.Close()
+			for _, topic := range []string{"a", "b", "a"} {
+				h.Publish(NewMessage(ReloadMessage, topic))
+			}
+
+			lastEventID := strings.Replace(tc.lastEventID, "current", h.instance, 1)
+			params := url.Values{}
+			if tc.codelabs != "" {
+				params.Set("codelabs", tc.codelabs)
+			}
+			if tc.asParameter {
+				params.Set("lastEventId", lastEventID)
+				lastEventID = ""
+			}
+			events, closeClient := connectSSE(t, ts.URL+"?"+params.Encode(), lastEventID)
+			defer closeClient()
+
+			var replayed []uint64
+			for {
+				m := wantEvent(t, events).message(t)
+				if m.Type != ReloadMessage {
+					if m.Type != tc.wantType {
+						t.Errorf("got %s message; want %s", m.Type, tc.wantType)
+					}
+					break
+				}
+				replayed = append(replayed, m.Seq)
+			}
+			if !reflect.DeepEqual(replayed, tc.wantReplay) {
+				t.Errorf("got messages %v replayed; want %v", replayed, tc.wantReplay)
+			}
+		})
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	defer func(period time.Duration) { sseHeartbeatPeriod = period }(sseHeartbeatPeriod)
+	sseHeartbeatPeriod = 10 * time.Millisecond
+	h, cleanup := createHub()
+	defer cleanup()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewSSEClient))
+	defer ts.Close()
+
+	events, closeClient := connectSSE(t, ts.URL, "")
+	defer closeClient()
+	wantEvent(t, events)
+
+	if e := wantEvent(t, events); e.comment != "heartbeat" {
+		t.Errorf("got %+v; want a heartbeat comment", e)
+	}
+}
+
+func TestSSEClientDisconnection(t *testing.T) {
+	h, cleanup := createHub()
+	defer cleanup()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewSSEClient))
+	defer ts.Close()
+
+	events, closeClient := connectSSE(t, ts.URL, "")
+	wantEvent(t, events)
+	h.muC.RLock()
+	if len(h.clients) != 1 {
+		t.Errorf("We expected 1 client to get registered. Got: %+v", h.clients)
+	}
+	h.muC.RUnlock()
+
+	closeClient()
+
+	// wait for the server to notice the disconnection and unregistration to proceed
+	for i := 0; i < 100; i++ {
+		h.muC.RLock()
+		n := len(h.clients)
+		h.muC.RUnlock()
+		if n == 0 {
+			return
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+	t.Error("We expected all clients to get deregistered")
+}
+
+func TestParseEventID(t *testing.T) {
+	testCases := []struct {
+		id string
+
+		wantInstance string
+		wantSeq      uint64
+	}{
+		{"abc:12", "abc", 12},
+		{"a:b:12", "a:b", 12},
+		{"abc:", "", 0},
+		{"abc:foo", "", 0},
+		{"abc", "", 0},
+		{"", "", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("parse %q", tc.id), func(t *testing.T) {
+			instance, seq := parseEventID(tc.id)
+			if instance != tc.wantInstance || seq != tc.wantSeq {
+				t.Errorf("got %q, %d; want %q, %d", instance, seq, tc.wantInstance, tc.wantSeq)
+			}
+		})
+	}
+}
+
+type sseTestEvent struct {
+	id      string
+	data    string
+	comment string
+}
+
+func (e sseTestEvent) message(t *testing.T) Message {
+	var m Message
+	if err := json.Unmarshal([]byte(e.data), &m); err != nil {
+		t.Fatalf("Couldn't decode event %+v: %v", e, err)
+	}
+	return m
+}
+
+// connectSSE returns events received from the server and a function to close the connection
+func connectSSE(t *testing.T, target, lastEventID string) (<-chan sseTestEvent, func()) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		t.Fatalf("Couldn't create request: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Couldn't connect: %v", err)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q; want text/event-stream", ct)
+	}
+
+	events := make(chan sseTestEvent, 100)
+	go func(body io.Reader) {
+		defer close(events)
+		s := bufio.NewScanner(body)
+		var e sseTestEvent
+		for s.Scan() {
+			l := s.Text()
+			switch {
+			case l == "":
+				events <- e
+				e = sseTestEvent{}
+			case strings.HasPrefix(l, ":"):
+				e.comment = strings.TrimSpace(strings.TrimPrefix(l, ":"))
+			case strings.HasPrefix(l, "id: "):
+				e.id = strings.TrimPrefix(l, "id: ")
+			case strings.HasPrefix(l, "data: "):
+				e.data += strings.TrimPrefix(l, "data: ")
+			}
+		}
+	}(res.Body)
+	return events, func() { res.Body.Close() }
+}
+
+func wantEvent(t *testing.T, events <-chan sseTestEvent) sseTestEvent {
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Connection closed while waiting for an event")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+	return sseTestEvent{}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -23,6 +24,23 @@ func TestHubStartStopNoClient(t *testing.T) {
 	}
 }
 
+func TestHubSendAfterStop(t *testing.T) {
+	h, stopHub := createHub()
+	stopHub()
+
+	done := make(chan struct{})
+	go func() {
+		h.Send([]byte("foo"))
+		h.SendTo([]string{"codelab"}, []byte("bar"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send() or SendTo() blocked on a stopped hub")
+	}
+}
+
 func TestHubRegisterDeregisterClients(t *testing.T) {
 	for _, numClient := range []int{0, 1, 2} {
 		t.Run(fmt.Sprintf("register and deregister %d clients", numClient), func(t *testing.T) {
@@ -195,6 +213,67 @@ func TestClientAbnormalCloseConnection(t *testing.T) {
 	h.muC.RUnlock()
 }
 
+func TestHubSubscriptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		messages []string
+
+		want []string
+	}{
+		{"default to all", nil, []string{"global", "for a", "for b", "for a and b"}},
+		{"subscribe to one codelab", []string{`{"type": "subscribe", "codelabs": ["a"]}`}, []string{"global", "for a", "for a and b"}},
+		{"subscribe to multiple codelabs", []string{`{"type": "subscribe", "codelabs": ["a", "b"]}`}, []string{"global", "for a", "for b", "for a and b"}},
+		{"subscribe to all", []string{`{"type": "subscribe", "codelabs": ["a"]}`, `{"type": "subscribe", "codelabs": ["all"]}`},
+			[]string{"global", "for a", "for b", "for a and b"}},
+		{"new subscription replaces previous one", []string{`{"type": "subscribe", "codelabs": ["a"]}`, `{"type": "subscribe", "codelabs": ["b"]}`},
+			[]string{"global", "for b", "for a and b"}},
+		{"unsubscribe", []string{`{"type": "subscribe", "codelabs": ["a", "b"]}`, `{"type": "unsubscribe", "codelabs": ["a"]}`},
+			[]string{"global", "for b", "for a and b"}},
+		{"unsubscribe from everything", []string{`{"type": "unsubscribe", "codelabs": ["all"]}`}, []string{"global"}},
+		{"invalid messages are ignored", []string{`not json`, `{"type": "unknown", "codelabs": ["a"]}`},
+			[]string{"global", "for a", "for b", "for a and b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+			defer ts.Close()
+			c, cleanup := addClient(t, ts.URL, h)
+			defer cleanup()
+
+			for _, msg := range tc.messages {
+				if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+					t.Fatalf("Couldn't send %s: %v", msg, err)
+				}
+			}
+			// wait for subscriptions to proceed
+			<-time.After(10 * time.Millisecond)
+
+			h.Send([]byte("global"))
+			h.SendTo([]string{"a"}, []byte("for a"))
+			h.SendTo([]string{"b"}, []byte("for b"))
+			h.SendTo([]string{"a", "b"}, []byte("for a and b"))
+			h.Send([]byte("end"))
+
+			var got []string
+			for {
+				_, rcv, err := c.ReadMessage()
+				if err != nil {
+					t.Fatalf("Unexpected received message error: %v", err)
+				}
+				if string(rcv) == "end" {
+					break
+				}
+				got = append(got, string(rcv))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("received %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
 // createHub and return a teardown cleanup function
 func createHub() (*Hub, func()) {
 	h := NewHub()
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"encoding/json"
 	"log"
 	"net"
 	"net/http"
@@ -18,35 +19,59 @@ const (
 
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
-)
 
-var upgrader = websocket.Upgrader{}
+	// Maximum message size allowed from peer.
+	maxMessageSize = 4096
+)
 
-// client is an middleman between the websocket connection and the hub.
+// client is an middleman between the websocket (or server-sent events) connection and the hub.
 type client struct {
 	hub *Hub
 
-	// The websocket connection.
+	// The websocket connection, nil for server-sent events clients.
 	conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
-	send chan []byte
+	// Outbound messages.
+	queue *queue
+
+	// Codelab IDs, or AllTopics, the client is subscribed to. Only accessed by the hub.
+	topics map[string]bool
+
+	// Presenter room the client joined, if any, and its role in it. Only accessed by the hub.
+	room      *room
+	presenter bool
+}
+
+// subscribed returns true if the client is interested in a message for those topics
+func (c *client) subscribed(topics []string) bool {
+	if len(topics) == 0 || c.topics[AllTopics] {
+		return true
+	}
+	for _, t := range topics {
+		if c.topics[t] {
+			return true
+		}
+	}
+	return false
 }
 
 // reader get messages from the websocket connection to the hub.
 func (c *client) reader() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.stop:
+		}
 		c.conn.Close()
 	}()
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			switch err.(type) {
 			case *net.OpError:
@@ -57,6 +82,20 @@ func (c *client) reader() {
 			}
 			break
 		}
+		c.handle(data)
+	}
+}
+
+// handle a message received from the client
+func (c *client) handle(data []byte) {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Printf("Invalid message from the Websocket client: %v", err)
+		return
+	}
+	select {
+	case c.hub.requests <- request{client: c, msg: m}:
+	case <-c.hub.stop:
 	}
 }
 
@@ -69,16 +108,24 @@ func (c *client) writer() {
 	}()
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case <-c.queue.ready:
+			for {
+				message, closed := c.queue.pop()
+				if closed {
+					// The hub removed the client, tell it why.
+					code, reason := c.queue.closeReason()
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+					return
+				}
+				if message == nil {
+					break
+				}
 
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				return
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					return
+				}
 			}
 
 		case <-pingTicker.C:
@@ -91,7 +138,12 @@ func (c *client) writer() {
 }
 
 // NewClient create a new client connected to our hub and listen/write to a websocket.
+// Connections from other origins than allowed ones or without the shared token are refused.
 func (hub *Hub) NewClient(w http.ResponseWriter, r *http.Request) {
+	if !hub.Accept(w, r) {
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: hub.checkOrigin}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		if _, ok := err.(websocket.HandshakeError); !ok {
@@ -99,7 +151,7 @@ func (hub *Hub) NewClient(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	client := &client{hub: hub, conn: conn, send: make(chan []byte, 10)}
+	client := &client{hub: hub, conn: conn, queue: newQueue(hub.bufferSize), topics: map[string]bool{AllTopics: true}}
 	client.hub.register <- client
 	go client.writer()
 	client.reader()
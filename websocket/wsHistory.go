@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"encoding/json"
+)
+
+// historySize is the number of latest messages kept for resuming clients
+const historySize = 64
+
+// record is a numbered message sent to clients
+type record struct {
+	seq    uint64
+	topics []string
+	key    string
+	data   []byte
+}
+
+// record numbers a message, encodes it and keeps it in history with its coalescing key
+func (h *Hub) record(topics []string, key string, m Message) ([]byte, error) {
+	h.seq++
+	m.Instance = h.instance
+	m.Seq = h.seq
+	data, err := json.Marshal(m)
+	if err != nil {
+		h.seq--
+		return nil, err
+	}
+	h.history = append(h.history, record{seq: m.Seq, topics: topics, key: key, data: data})
+	if len(h.history) > historySize {
+		h.history = h.history[len(h.history)-historySize:]
+	}
+	return data, nil
+}
+
+// resume sends to a reconnecting client the messages it missed since the last one it saw, coalescing redundant ones.
+// If it doesn't know the current instance, it's only told the current sequence number.
+// If some of them aren't in history anymore, can't be queued at once or it comes from another instance,
+// it's asked to fully reload.
+func (h *Hub) resume(c *client, m Message) {
+	if m.Instance != "" {
+		if m.Instance != h.instance || m.Seq > h.seq || !h.hasHistorySince(m.Seq) {
+			h.sendMessage(c, h.syncMessage(FullReloadMessage))
+			return
+		}
+		// only the latest of redundant messages is replayed
+		latest := make(map[string]uint64)
+		for _, r := range h.history {
+			if r.key != "" {
+				latest[r.key] = r.seq
+			}
+		}
+		var missed []record
+		for _, r := range h.history {
+			if r.seq > m.Seq && c.subscribed(r.topics) && (r.key == "" || latest[r.key] == r.seq) {
+				missed = append(missed, r)
+			}
+		}
+		// keep room for the synced message
+		if len(missed) >= c.queue.free() {
+			h.sendMessage(c, h.syncMessage(FullReloadMessage))
+			return
+		}
+		for _, r := range missed {
+			h.deliver(c, r.key, r.data)
+		}
+	}
+	h.sendMessage(c, h.syncMessage(SyncedMessage))
+}
+
+// hasHistorySince returns true if every message after seq is still in history
+func (h *Hub) hasHistorySince(seq uint64) bool {
+	if seq == h.seq {
+		return true
+	}
+	return len(h.history) > 0 && h.history[0].seq <= seq+1
+}
+
+// syncMessage returns a message with the current instance and sequence number
+func (h *Hub) syncMessage(msgType string) Message {
+	m := NewMessage(msgType)
+	m.Instance = h.instance
+	m.Seq = h.seq
+	return m
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	testCases := []struct {
+		name       string
+		published  int
+		subscribe  []string
+		instance   string // "current" is replaced by the hub instance
+		seq        uint64
+		wantReplay []uint64
+		wantType   string
+		wantSeq    uint64
+	}{
+		{"new client", 4, nil, "", 0, nil, SyncedMessage, 4},
+		{"missed messages", 4, nil, "current", 2, []uint64{3, 4}, SyncedMessage, 4},
+		{"missed messages before anything was sent", 2, nil, "current", 0, []uint64{1, 2}, SyncedMessage, 2},
+		{"up to date", 4, nil, "current", 4, nil, SyncedMessage, 4},
+		{"nothing sent", 0, nil, "current", 0, nil, SyncedMessage, 0},
+		{"only subscribed messages are replayed", 4, []string{"b"}, "current", 0, []uint64{2, 4}, SyncedMessage, 4},
+		{"server restarted", 4, nil, "previous", 2, nil, FullReloadMessage, 4},
+		{"server restarted with less messages", 4, nil, "current", 6, nil, FullReloadMessage, 4},
+		{"too many missed messages", historySize + 2, nil, "current", 1, nil, FullReloadMessage, historySize + 2},
+		{"oldest messages in history", historySize + 2, nil, "current", 2, seqs(3, historySize+2), SyncedMessage, historySize + 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+			defer ts.Close()
+			// odd messages are for codelab a, even ones for codelab b
+			for i := 1; i <= tc.published; i++ {
+				topic := "a"
+				if i%2 == 0 {
+					topic = "b"
+				}
+				h.Publish(NewMessage(BuildSucceededMessage, topic))
+			}
+
+			c, cleanup := addClient(t, ts.URL, h)
+			defer cleanup()
+			if tc.subscribe != nil {
+				if err := c.WriteJSON(NewMessage(SubscribeMessage, tc.subscribe...)); err != nil {
+					t.Fatalf("Couldn't subscribe: %v", err)
+				}
+			}
+			m := NewMessage(ResumeMessage)
+			m.Instance, m.Seq = tc.instance, tc.seq
+			if tc.instance == "current" {
+				m.Instance = h.instance
+			}
+			if err := c.WriteJSON(m); err != nil {
+				t.Fatalf("Couldn't resume: %v", err)
+			}
+
+			var replayed []uint64
+			for {
+				m := readMessage(t, c)
+				if m.Type != BuildSucceededMessage {
+					if m.Type != tc.wantType {
+						t.Errorf("got %s message; want %s", m.Type, tc.wantType)
+					}
+					if m.Seq != tc.wantSeq || m.Instance != h.instance {
+						t.Errorf("got sequence %d of %q; want %d of %q", m.Seq, m.Instance, tc.wantSeq, h.instance)
+					}
+					break
+				}
+				replayed = append(replayed, m.Seq)
+			}
+			if !reflect.DeepEqual(replayed, tc.wantReplay) {
+				t.Errorf("got messages %v replayed; want %v", replayed, tc.wantReplay)
+			}
+		})
+	}
+}
+
+// seqs returns all sequence numbers from first to last
+func seqs(first, last uint64) []uint64 {
+	var s []uint64
+	for i := first; i <= last; i++ {
+		s = append(s, i)
+	}
+	return s
+}
@@ -1,30 +1,94 @@
 package websocket
 
 import (
+	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the clients.
+// AllTopics is the subscription to every codelab, which is the default one
+const AllTopics = "all"
+
+// DefaultBufferSize is the number of messages queued for a client before dropping it,
+// with enough room for a full history replay.
+const DefaultBufferSize = historySize + 16
+
+// Hub maintains the set of active clients and broadcasts messages to the clients
+// subscribed to their topics.
 type Hub struct {
-	broadcast chan []byte
+	// activity counters, first for 64-bit alignment of atomic operations
+	delivered uint64
+	coalesced uint64
+	dropped   uint64
+
+	broadcast chan envelope
+
+	// number of messages queued for each client
+	bufferSize int
+
+	// origins, besides the served host, and shared token required to connect
+	allowedOrigins []string
+	token          string
 
 	// the mutex is only for the tests
 	muC     *sync.RWMutex
 	clients map[*client]bool
 
+	// presenter rooms, by name, and code required to present in them
+	rooms         map[string]*room
+	presenterCode string
+
+	// numbered messages history, for clients to resume after a disconnection
+	instance string
+	seq      uint64
+	history  []record
+
 	register   chan *client
 	unregister chan *client
+	requests   chan request
 	stop       chan struct{}
 }
 
+// envelope is a message to send to clients subscribed to one of its topics.
+// A message without topic is sent to every client.
+// Json messages are numbered by the hub before being encoded, while raw data are sent as is.
+type envelope struct {
+	topics []string
+	msg    *Message
+	data   []byte
+}
+
+// request is a message received from a client
+type request struct {
+	client *client
+	msg    Message
+}
+
+// Stats are counters of the hub activity, for debugging
+type Stats struct {
+	Clients    int    `json:"clients"`
+	BufferSize int    `json:"bufferSize"`
+	Delivered  uint64 `json:"delivered"`
+	Coalesced  uint64 `json:"coalesced"`
+	Dropped    uint64 `json:"dropped"`
+}
+
 // NewHub generate the main Hub object to connect clients to it
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan envelope),
+		bufferSize: DefaultBufferSize,
 		muC:        &sync.RWMutex{},
 		clients:    make(map[*client]bool),
+		rooms:      make(map[string]*room),
+		instance:   fmt.Sprintf("%x", time.Now().UnixNano()),
 		register:   make(chan *client),
 		unregister: make(chan *client),
+		requests:   make(chan request),
 		stop:       make(chan struct{}),
 	}
 }
@@ -38,35 +102,117 @@ func (h *Hub) Run() {
 			h.clients[client] = true
 			h.muC.Unlock()
 		case client := <-h.unregister:
-			h.muC.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+			h.remove(client, websocket.CloseNormalClosure, "")
+		case r := <-h.requests:
+			if _, ok := h.clients[r.client]; !ok {
+				continue
 			}
-			h.muC.Unlock()
+			h.handle(r.client, r.msg)
 		case message := <-h.broadcast:
+			key := coalescingKey(message.msg, message.data)
+			if message.msg != nil {
+				data, err := h.record(message.topics, key, *message.msg)
+				if err != nil {
+					log.Printf("Couldn't encode %s message: %v", message.msg.Type, err)
+					continue
+				}
+				message.data = data
+			}
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					go func() {
-						// handle that in a goroutine as the receiver is in the same goroutine
-						h.unregister <- client
-					}()
+				if !client.subscribed(message.topics) {
+					continue
 				}
+				h.deliver(client, key, message.data)
 			}
 		case <-h.stop:
 			for c := range h.clients {
-				c.conn.Close()
-				h.muC.Lock()
-				delete(h.clients, c)
-				h.muC.Unlock()
+				h.remove(c, websocket.CloseGoingAway, "server stopped")
 			}
 			return
 		}
 	}
 }
 
+// handle a message received from a client
+func (h *Hub) handle(c *client, m Message) {
+	if !h.validToken(m.Token) {
+		h.refuse(c, "invalid or missing token")
+		return
+	}
+	switch m.Type {
+	case SubscribeMessage:
+		c.topics = make(map[string]bool)
+		for _, t := range m.Codelabs {
+			c.topics[t] = true
+		}
+	case UnsubscribeMessage:
+		for _, t := range m.Codelabs {
+			delete(c.topics, t)
+		}
+	case JoinMessage:
+		h.join(c, m)
+	case LeaveMessage:
+		h.leave(c)
+	case NavigateMessage:
+		h.navigate(c, m)
+	case ResumeMessage:
+		h.resume(c, m)
+	default:
+		log.Printf("Unsupported message type from the Websocket client: %q", m.Type)
+	}
+}
+
+// deliver queues a message for a client, coalescing it with a pending one with the same key.
+// The client is dropped if it can't keep up.
+func (h *Hub) deliver(c *client, key string, data []byte) {
+	coalesced, ok := c.queue.push(key, data)
+	if coalesced {
+		atomic.AddUint64(&h.coalesced, 1)
+	}
+	if !ok {
+		log.Printf("Dropping client: more than %d messages pending", c.queue.size)
+		atomic.AddUint64(&h.dropped, 1)
+		h.remove(c, websocket.CloseTryAgainLater, fmt.Sprintf("too slow: more than %d messages pending", c.queue.size))
+		return
+	}
+	atomic.AddUint64(&h.delivered, 1)
+}
+
+// remove unregisters a client and closes its connection with that code and reason
+func (h *Hub) remove(c *client, code int, reason string) {
+	h.muC.Lock()
+	defer h.muC.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	h.leave(c)
+	delete(h.clients, c)
+	c.queue.close(code, reason)
+}
+
+// SetBufferSize changes the number of messages queued for each client before dropping it.
+// It only applies to clients connecting afterwards, and should be called before serving any.
+func (h *Hub) SetBufferSize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	h.bufferSize = size
+}
+
+// Stats returns counters of the hub activity
+func (h *Hub) Stats() Stats {
+	h.muC.RLock()
+	clients := len(h.clients)
+	h.muC.RUnlock()
+	return Stats{
+		Clients:    clients,
+		BufferSize: h.bufferSize,
+		Delivered:  atomic.LoadUint64(&h.delivered),
+		Coalesced:  atomic.LoadUint64(&h.coalesced),
+		Dropped:    atomic.LoadUint64(&h.dropped),
+	}
+}
+
 // Stop hub and disconnect all clients
 func (h *Hub) Stop() {
 	close(h.stop)
@@ -74,5 +220,15 @@ func (h *Hub) Stop() {
 
 // Send a message to all connected clients
 func (h *Hub) Send(msg []byte) {
-	h.broadcast <- msg
+	h.SendTo(nil, msg)
+}
+
+// SendTo sends a message to clients subscribed to any of the given topics (codelab IDs).
+// Without any topic, the message is sent to all connected clients. Messages sent once the hub is
+// stopped are dropped.
+func (h *Hub) SendTo(topics []string, msg []byte) {
+	select {
+	case h.broadcast <- envelope{topics: topics, data: msg}:
+	case <-h.stop:
+	}
 }
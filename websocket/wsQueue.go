@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// queue holds the messages waiting to be written to a client.
+// Messages with the same coalescing key are redundant: only the latest one is kept.
+type queue struct {
+	mu    sync.Mutex
+	items []queued
+	size  int
+
+	// ready is signaled when messages are pushed or the queue is closed
+	ready chan struct{}
+
+	closed bool
+	code   int
+	reason string
+}
+
+// queued is a message waiting to be written, with its coalescing key (empty if it can't be coalesced)
+type queued struct {
+	key  string
+	data []byte
+}
+
+func newQueue(size int) *queue {
+	return &queue{size: size, ready: make(chan struct{}, 1)}
+}
+
+// push adds a message at the end of the queue, replacing any pending message with the same key.
+// It returns if a message was coalesced and false for ok if the queue is full.
+func (q *queue) push(key string, data []byte) (coalesced bool, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, true
+	}
+	if key != "" {
+		for i, item := range q.items {
+			if item.key == key {
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				coalesced = true
+				break
+			}
+		}
+	}
+	if len(q.items) >= q.size {
+		return coalesced, false
+	}
+	q.items = append(q.items, queued{key: key, data: data})
+	q.signal()
+	return coalesced, true
+}
+
+// pop returns the next message to write. Once there is none, closed tells if the queue was closed.
+func (q *queue) pop() (data []byte, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return nil, q.closed
+	}
+	data = q.items[0].data
+	q.items[0].data = nil
+	q.items = q.items[1:]
+	return data, false
+}
+
+// free returns the number of messages which can still be pushed
+func (q *queue) free() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size - len(q.items)
+}
+
+// close drops pending messages and tells the writer to close the connection with that code and reason.
+// Closing a closed queue doesn't change the initial code and reason.
+func (q *queue) close(code int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.code = code
+	q.reason = reason
+	q.items = nil
+	q.signal()
+}
+
+// closeReason returns the code and reason the queue was closed with
+func (q *queue) closeReason() (int, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.code, q.reason
+}
+
+func (q *queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// coalescingKey returns the key identifying redundant messages: reloads of the same codelabs
+// and identical raw data (like legacy plain urls to reload).
+// Other messages are never coalesced.
+func coalescingKey(m *Message, data []byte) string {
+	if m == nil {
+		return "raw:" + string(data)
+	}
+	if m.Type != ReloadMessage {
+		return ""
+	}
+	ids := append([]string(nil), m.Codelabs...)
+	sort.Strings(ids)
+	urls := append([]string(nil), m.URLs...)
+	sort.Strings(urls)
+	return ReloadMessage + ":" + strings.Join(ids, ",") + ":" + strings.Join(urls, ",")
+}
@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestQueue(t *testing.T) {
+	testCases := []struct {
+		name string
+		size int
+		push []queued
+
+		want          []string
+		wantCoalesced int
+		wantFull      bool
+	}{
+		{"in order", 3, []queued{{"", []byte("a")}, {"", []byte("b")}}, []string{"a", "b"}, 0, false},
+		{"latest replaces redundant one", 3, []queued{{"k", []byte("a")}, {"", []byte("b")}, {"k", []byte("c")}},
+			[]string{"b", "c"}, 1, false},
+		{"different keys are kept", 3, []queued{{"k", []byte("a")}, {"l", []byte("b")}}, []string{"a", "b"}, 0, false},
+		{"full", 2, []queued{{"", []byte("a")}, {"", []byte("b")}, {"", []byte("c")}}, []string{"a", "b"}, 0, true},
+		{"coalescing makes room", 2, []queued{{"k", []byte("a")}, {"", []byte("b")}, {"k", []byte("c")}},
+			[]string{"b", "c"}, 1, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newQueue(tc.size)
+			coalesced, full := 0, false
+			for _, item := range tc.push {
+				c, ok := q.push(item.key, item.data)
+				if c {
+					coalesced++
+				}
+				if !ok {
+					full = true
+				}
+			}
+
+			var got []string
+			for {
+				data, closed := q.pop()
+				if closed {
+					t.Fatal("Queue shouldn't be closed")
+				}
+				if data == nil {
+					break
+				}
+				got = append(got, string(data))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+			if coalesced != tc.wantCoalesced {
+				t.Errorf("got %d messages coalesced; want %d", coalesced, tc.wantCoalesced)
+			}
+			if full != tc.wantFull {
+				t.Errorf("got full: %v; want %v", full, tc.wantFull)
+			}
+		})
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	q := newQueue(2)
+	q.push("", []byte("a"))
+	q.close(websocket.CloseGoingAway, "bye")
+	q.close(websocket.CloseNormalClosure, "")
+
+	if _, ok := q.push("", []byte("b")); !ok {
+		t.Error("Pushing to a closed queue should be ignored")
+	}
+	if data, closed := q.pop(); data != nil || !closed {
+		t.Errorf("got %q, closed: %v; want pending messages dropped and a closed queue", data, closed)
+	}
+	if code, reason := q.closeReason(); code != websocket.CloseGoingAway || reason != "bye" {
+		t.Errorf("got closed with %d %q; want %d %q", code, reason, websocket.CloseGoingAway, "bye")
+	}
+}
+
+func TestCoalescingKey(t *testing.T) {
+	reload := NewMessage(ReloadMessage, "b", "a")
+	sameReload := NewMessage(ReloadMessage, "a", "b")
+	otherReload := NewMessage(ReloadMessage, "a")
+	failed := NewMessage(BuildFailedMessage, "a")
+
+	if coalescingKey(&reload, nil) != coalescingKey(&sameReload, nil) {
+		t.Error("Reloads of the same codelabs should be coalesced")
+	}
+	if coalescingKey(&reload, nil) == coalescingKey(&otherReload, nil) {
+		t.Error("Reloads of different codelabs shouldn't be coalesced")
+	}
+	if k := coalescingKey(&failed, nil); k != "" {
+		t.Errorf("Only reloads should be coalesced. Got key %q", k)
+	}
+	if coalescingKey(nil, []byte("/a")) != coalescingKey(nil, []byte("/a")) || coalescingKey(nil, []byte("/a")) == coalescingKey(nil, []byte("/b")) {
+		t.Error("Only identical raw data should be coalesced")
+	}
+}
+
+func TestHubSlowClients(t *testing.T) {
+	testCases := []struct {
+		bufferSize int
+		messages   []Message
+
+		wantPending   int
+		wantDropped   bool
+		wantCoalesced uint64
+	}{
+		{2, []Message{NewMessage(ReloadMessage, "a"), NewMessage(ReloadMessage, "b")}, 2, false, 0},
+		{2, []Message{NewMessage(ReloadMessage, "a"), NewMessage(ReloadMessage, "a"), NewMessage(ReloadMessage, "a")}, 1, false, 2},
+		{2, []Message{NewMessage(ReloadMessage, "a"), NewMessage(ReloadMessage, "b"), NewMessage(ReloadMessage, "c")}, 0, true, 0},
+		{2, []Message{NewMessage(BuildFailedMessage, "a"), NewMessage(BuildFailedMessage, "a"), NewMessage(BuildFailedMessage, "a")}, 0, true, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("send %d messages to a client with a buffer of %d", len(tc.messages), tc.bufferSize), func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			h.SetBufferSize(tc.bufferSize)
+
+			// a client never writing anything
+			c := &client{hub: h, queue: newQueue(h.bufferSize), topics: map[string]bool{AllTopics: true}}
+			h.register <- c
+			for _, m := range tc.messages {
+				h.Publish(m)
+			}
+			// wait for the hub to process every message
+			h.unregister <- &client{}
+
+			if pending := tc.bufferSize - c.queue.free(); pending != tc.wantPending {
+				t.Errorf("got %d messages pending; want %d", pending, tc.wantPending)
+			}
+			_, closed := c.queue.pop()
+			if closed != tc.wantDropped {
+				t.Errorf("got client dropped: %v; want %v", closed, tc.wantDropped)
+			}
+			if code, _ := c.queue.closeReason(); tc.wantDropped && code != websocket.CloseTryAgainLater {
+				t.Errorf("got client closed with %d; want %d", code, websocket.CloseTryAgainLater)
+			}
+
+			s := h.Stats()
+			wantClients, wantDropped := 1, uint64(0)
+			if tc.wantDropped {
+				wantClients, wantDropped = 0, 1
+			}
+			if s.Clients != wantClients || s.Dropped != wantDropped || s.Coalesced != tc.wantCoalesced || s.BufferSize != tc.bufferSize {
+				t.Errorf("got stats %+v; want %d clients, %d dropped, %d coalesced and a buffer of %d",
+					s, wantClients, wantDropped, tc.wantCoalesced, tc.bufferSize)
+			}
+		})
+	}
+}
+
+func TestHubStopClosesClients(t *testing.T) {
+	h, cleanup := createHub()
+	ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+	defer ts.Close()
+	c, closeClient := addClient(t, ts.URL, h)
+	defer closeClient()
+
+	cleanup()
+
+	_, _, err := c.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v; want a close frame telling the server stopped", err)
+	}
+}
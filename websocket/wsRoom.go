@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+)
+
+// room is a presenter session: presenters share their position with everyone in it.
+// It's created by its first presenter and protected by a code shared with attendees. Presenters also
+// need the presenter code of the server, which attendees don't know.
+type room struct {
+	name    string
+	code    string
+	members map[*client]bool
+
+	// latest presenter position, sent to clients joining late
+	position *Position
+}
+
+// SetPresenterCode sets the code presenters need to create rooms and navigate. An empty code disables
+// presenting: attendees can't create rooms either.
+// It should be called before serving any client.
+func (h *Hub) SetPresenterCode(code string) {
+	h.presenterCode = code
+}
+
+// join adds a client to a room. Only presenters can create it. The client leaves its previous room.
+func (h *Hub) join(c *client, m Message) {
+	if m.Room == "" || m.Code == "" {
+		h.refuse(c, "a room name and code are required")
+		return
+	}
+	if m.Role != PresenterRole && m.Role != FollowerRole {
+		h.refuse(c, "role should be either "+PresenterRole+" or "+FollowerRole)
+		return
+	}
+	if m.Role == PresenterRole {
+		if h.presenterCode == "" {
+			h.refuse(c, "presenting is disabled: the server has no presenter code")
+			return
+		}
+		if !sameCode(h.presenterCode, m.PresenterCode) {
+			h.refuse(c, "invalid presenter code")
+			return
+		}
+		// attendees know the room code
+		if sameCode(h.presenterCode, m.Code) {
+			h.refuse(c, "the room code should be different from the presenter code")
+			return
+		}
+	}
+
+	r, ok := h.rooms[m.Room]
+	if !ok && m.Role != PresenterRole {
+		h.refuse(c, "room "+m.Room+" doesn't exist: waiting for its presenter")
+		return
+	}
+	if ok && !sameCode(r.code, m.Code) {
+		h.refuse(c, "invalid code for room "+m.Room)
+		return
+	}
+	h.leave(c)
+	if !ok {
+		r = &room{name: m.Room, code: m.Code, members: make(map[*client]bool)}
+		h.rooms[m.Room] = r
+	}
+	r.members[c] = true
+	c.room = r
+	c.presenter = m.Role == PresenterRole
+
+	joined := NewMessage(JoinedMessage)
+	joined.Room = r.name
+	joined.Role = m.Role
+	h.sendMessage(c, joined)
+	if r.position != nil {
+		h.sendMessage(c, r.navigation())
+	}
+}
+
+// sameCode compares room or presenter codes in constant time
+func sameCode(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// leave removes a client from its room, if any. Empty rooms are removed.
+func (h *Hub) leave(c *client) {
+	r := c.room
+	if r == nil {
+		return
+	}
+	delete(r.members, c)
+	c.room = nil
+	c.presenter = false
+	if len(r.members) == 0 {
+		delete(h.rooms, r.name)
+	}
+}
+
+// navigate records the position of a presenter and relays it to other room members
+func (h *Hub) navigate(c *client, m Message) {
+	if c.room == nil || !c.presenter {
+		h.refuse(c, "only presenters of a room can navigate")
+		return
+	}
+	if m.Position == nil || m.Position.Codelab == "" {
+		h.refuse(c, "a codelab position is required to navigate")
+		return
+	}
+	r := c.room
+	p := *m.Position
+	r.position = &p
+
+	b, err := json.Marshal(r.navigation())
+	if err != nil {
+		log.Printf("Couldn't encode navigation message: %v", err)
+		return
+	}
+	// only the latest position matters to members lagging behind
+	for member := range r.members {
+		if member == c {
+			continue
+		}
+		h.deliver(member, NavigateMessage+":"+r.name, b)
+	}
+}
+
+// navigation returns the message with the latest room position
+func (r *room) navigation() Message {
+	m := NewMessage(NavigateMessage)
+	m.Room = r.name
+	p := *r.position
+	m.Position = &p
+	return m
+}
+
+// refuse a client request, explaining why
+func (h *Hub) refuse(c *client, reason string) {
+	m := NewMessage(ErrorMessage)
+	m.Reason = reason
+	h.sendMessage(c, m)
+}
+
+// sendMessage sends a message in json to a single client
+func (h *Hub) sendMessage(c *client, m Message) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Couldn't encode %s message: %v", m.Type, err)
+		return
+	}
+	h.deliver(c, "", b)
+}
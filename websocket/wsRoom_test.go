@@ -0,0 +1,227 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomJoin(t *testing.T) {
+	testCases := []struct {
+		room          string
+		code          string
+		presenterCode string
+		role          string
+
+		wantType string
+	}{
+		{"workshop", "secret", "presenter secret", PresenterRole, JoinedMessage},
+		{"workshop", "secret", "", FollowerRole, JoinedMessage},
+		{"other", "other secret", "presenter secret", PresenterRole, JoinedMessage},      // new room
+		{"other", "other secret", "", FollowerRole, ErrorMessage},                        // only presenters create rooms
+		{"other", "other secret", "other presenter secret", PresenterRole, ErrorMessage}, // the presenter code is the server one
+		{"other", "presenter secret", "presenter secret", PresenterRole, ErrorMessage},   // room code is different
+		{"workshop", "secret", "", PresenterRole, ErrorMessage},                          // presenters need a presenter code
+		{"workshop", "secret", "secret", PresenterRole, ErrorMessage},                    // followers can't present
+		{"workshop", "secret", "wrong", PresenterRole, ErrorMessage},
+		{"workshop", "wrong", "presenter secret", PresenterRole, ErrorMessage},
+		{"workshop", "wrong", "", FollowerRole, ErrorMessage},
+		{"workshop", "", "", FollowerRole, ErrorMessage},
+		{"", "secret", "", FollowerRole, ErrorMessage},
+		{"workshop", "secret", "", "", ErrorMessage},
+		{"workshop", "secret", "", "unknown", ErrorMessage},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("join room %q with code %q and presenter code %q as %q", tc.room, tc.code, tc.presenterCode, tc.role), func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			h.SetPresenterCode("presenter secret")
+			ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+			defer ts.Close()
+			// an existing workshop room
+			presenter, cleanup := addClient(t, ts.URL, h)
+			defer cleanup()
+			joinRoom(t, presenter, "workshop", "secret", "presenter secret", PresenterRole)
+			wantMessage(t, presenter, JoinedMessage)
+
+			c, cleanup := addClient(t, ts.URL, h)
+			defer cleanup()
+			joinRoom(t, c, tc.room, tc.code, tc.presenterCode, tc.role)
+
+			m := wantMessage(t, c, tc.wantType)
+			if tc.wantType == ErrorMessage {
+				if m.Reason == "" {
+					t.Error("refused requests should have a reason")
+				}
+				return
+			}
+			if m.Room != tc.room || m.Role != tc.role {
+				t.Errorf("joined room %q as %q; want room %q as %q", m.Room, m.Role, tc.room, tc.role)
+			}
+		})
+	}
+}
+
+func TestRoomNavigation(t *testing.T) {
+	h, cleanup := createHub()
+	defer cleanup()
+	h.SetPresenterCode("presenter secret")
+	ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+	defer ts.Close()
+
+	presenter, cleanup := addClient(t, ts.URL, h)
+	defer cleanup()
+	joinRoom(t, presenter, "workshop", "secret", "presenter secret", PresenterRole)
+	wantMessage(t, presenter, JoinedMessage)
+	follower, cleanup := addClient(t, ts.URL, h)
+	defer cleanup()
+	joinRoom(t, follower, "workshop", "secret", "", FollowerRole)
+	wantMessage(t, follower, JoinedMessage)
+	other, cleanup := addClient(t, ts.URL, h)
+	defer cleanup()
+	joinRoom(t, other, "other", "secret", "presenter secret", PresenterRole)
+	wantMessage(t, other, JoinedMessage)
+
+	navigate(t, presenter, "codelab-a", 3)
+
+	// followers of the room are following the presenter
+	wantPosition(t, follower, Position{Codelab: "codelab-a", Step: 3})
+
+	// followers can't navigate
+	navigate(t, follower, "codelab-b", 1)
+	wantMessage(t, follower, ErrorMessage)
+
+	// late joiners get latest position
+	navigate(t, presenter, "codelab-a", 4)
+	wantPosition(t, follower, Position{Codelab: "codelab-a", Step: 4})
+	late, cleanup := addClient(t, ts.URL, h)
+	defer cleanup()
+	joinRoom(t, late, "workshop", "secret", "", FollowerRole)
+	wantMessage(t, late, JoinedMessage)
+	wantPosition(t, late, Position{Codelab: "codelab-a", Step: 4})
+
+	// presenter and other rooms don't get navigation messages
+	h.Send([]byte(`{"type": "end"}`))
+	for _, c := range []*websocket.Conn{presenter, other} {
+		wantMessage(t, c, "end")
+	}
+}
+
+func TestRoomRemovedOnceEmpty(t *testing.T) {
+	h, cleanup := createHub()
+	defer cleanup()
+	h.SetPresenterCode("presenter secret")
+	ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+	defer ts.Close()
+
+	c, cleanup := addClient(t, ts.URL, h)
+	defer cleanup()
+	joinRoom(t, c, "workshop", "secret", "presenter secret", PresenterRole)
+	wantMessage(t, c, JoinedMessage)
+	navigate(t, c, "codelab-a", 3)
+	if err := c.WriteJSON(NewMessage(LeaveMessage)); err != nil {
+		t.Fatalf("Couldn't leave room: %v", err)
+	}
+
+	// the room can be created again with another code, without previous position
+	joinRoom(t, c, "workshop", "new secret", "presenter secret", PresenterRole)
+	wantMessage(t, c, JoinedMessage)
+	h.Send([]byte(`{"type": "end"}`))
+	wantMessage(t, c, "end")
+}
+
+func TestRoomCantBeClaimedByAttendees(t *testing.T) {
+	testCases := []struct {
+		presenterCode string
+		emptied       bool
+	}{
+		{"presenter secret", false},
+		{"presenter secret", true},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("with presenter code %q, room emptied: %v", tc.presenterCode, tc.emptied), func(t *testing.T) {
+			h, cleanup := createHub()
+			defer cleanup()
+			h.SetPresenterCode(tc.presenterCode)
+			ts := httptest.NewServer(http.HandlerFunc(h.NewClient))
+			defer ts.Close()
+
+			if tc.emptied {
+				presenter, cleanup := addClient(t, ts.URL, h)
+				joinRoom(t, presenter, "workshop", "secret", tc.presenterCode, PresenterRole)
+				wantMessage(t, presenter, JoinedMessage)
+				// the room is removed with its last member
+				cleanup()
+				for h.Stats().Clients > 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			// an attendee knowing the room name and code tries to present with a code of their own
+			attendee, cleanup := addClient(t, ts.URL, h)
+			defer cleanup()
+			joinRoom(t, attendee, "workshop", "secret", "attendee secret", PresenterRole)
+			wantMessage(t, attendee, ErrorMessage)
+			joinRoom(t, attendee, "workshop", "secret", "", PresenterRole)
+			wantMessage(t, attendee, ErrorMessage)
+			joinRoom(t, attendee, "workshop", "secret", "", FollowerRole)
+			wantMessage(t, attendee, ErrorMessage)
+
+			// the instructor can still create the room, when presenting is enabled
+			instructor, cleanup := addClient(t, ts.URL, h)
+			defer cleanup()
+			joinRoom(t, instructor, "workshop", "secret", "presenter secret", PresenterRole)
+			if tc.presenterCode == "" {
+				wantMessage(t, instructor, ErrorMessage)
+				return
+			}
+			wantMessage(t, instructor, JoinedMessage)
+		})
+	}
+}
+
+func joinRoom(t *testing.T, c *websocket.Conn, room, code, presenterCode, role string) {
+	m := NewMessage(JoinMessage)
+	m.Room, m.Code, m.PresenterCode, m.Role = room, code, presenterCode, role
+	if err := c.WriteJSON(m); err != nil {
+		t.Fatalf("Couldn't join room: %v", err)
+	}
+}
+
+func navigate(t *testing.T, c *websocket.Conn, codelab string, step int) {
+	m := NewMessage(NavigateMessage)
+	m.Position = &Position{Codelab: codelab, Step: step}
+	if err := c.WriteJSON(m); err != nil {
+		t.Fatalf("Couldn't navigate: %v", err)
+	}
+}
+
+func readMessage(t *testing.T, c *websocket.Conn) Message {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	var m Message
+	if err := c.ReadJSON(&m); err != nil {
+		t.Fatalf("Unexpected received message error: %v", err)
+	}
+	return m
+}
+
+func wantMessage(t *testing.T, c *websocket.Conn, msgType string) Message {
+	m := readMessage(t, c)
+	if m.Type != msgType {
+		t.Fatalf("received %+v; want a %s message", m, msgType)
+	}
+	return m
+}
+
+func wantPosition(t *testing.T, c *websocket.Conn, want Position) {
+	m := wantMessage(t, c, NavigateMessage)
+	if m.Position == nil || !reflect.DeepEqual(*m.Position, want) {
+		t.Errorf("got position %+v; want %+v", m.Position, want)
+	}
+}